@@ -0,0 +1,107 @@
+package chatgpt_go
+
+import "context"
+
+// activeSend is a send in flight, tracked until it returns.
+type activeSend struct {
+	// conversationId is the id of the conversation, set as soon as the
+	// response reports it for new conversations.
+	conversationId string
+	cancel         context.CancelFunc
+}
+
+type activeSendKey struct{}
+
+// ActiveConversations returns the conversations with a send in flight.
+func (c *ChatGPT) ActiveConversations() []*Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conversations := make([]*Conversation, 0, len(c.active))
+	for conversation := range c.active {
+		conversations = append(conversations, conversation)
+	}
+	return conversations
+}
+
+// CancelConversation cancels the sends in flight on the conversation with
+// the given id, which then fail with context.Canceled, and reports whether
+// there were any. A new conversation can be cancelled once the backend
+// reported its id, e.g. to OnConversationID.
+func (c *ChatGPT) CancelConversation(conversationId string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancelled := false
+	for _, sends := range c.active {
+		for send := range sends {
+			if conversationId != "" && send.conversationId == conversationId {
+				send.cancel()
+				cancelled = true
+			}
+		}
+	}
+	return cancelled
+}
+
+// WaitIdle blocks until no conversation of the client has a send in flight
+// or ctx is done, e.g. to drain a server on shutdown.
+func (c *ChatGPT) WaitIdle(ctx context.Context) error {
+	c.mu.Lock()
+	if len(c.active) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	idle := c.idle
+	c.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track marks conversation as having a send in flight until the returned
+// func is called. The returned context is ctx, cancelled by
+// CancelConversation and once the send is over.
+func (c *ChatGPT) track(ctx context.Context, conversation *Conversation) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	send := &activeSend{conversationId: conversation.ConversationId, cancel: cancel}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active == nil {
+		c.active = map[*Conversation]map[*activeSend]struct{}{}
+	}
+	if c.active[conversation] == nil {
+		c.active[conversation] = map[*activeSend]struct{}{}
+	}
+	c.active[conversation][send] = struct{}{}
+	return context.WithValue(ctx, activeSendKey{}, send), func() {
+		cancel()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if delete(c.active[conversation], send); len(c.active[conversation]) > 0 {
+			return
+		}
+		delete(c.active, conversation)
+		if len(c.active) == 0 && c.idle != nil {
+			close(c.idle)
+			c.idle = nil
+		}
+	}
+}
+
+// trackConversationId records the conversation id reported by the response
+// of the send ctx belongs to.
+func (c *ChatGPT) trackConversationId(ctx context.Context, conversationId string) {
+	send, ok := ctx.Value(activeSendKey{}).(*activeSend)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	send.conversationId = conversationId
+	c.mu.Unlock()
+}
@@ -0,0 +1,64 @@
+package chatgpt_go_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_WaitIdle(t *testing.T) {
+	release := make(chan struct{})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	assert.NoError(t, client.WaitIdle(context.Background()))
+
+	conversation := client.NewConversation("", "")
+	done := make(chan error)
+	go func() {
+		_, err := conversation.SendMessage("hello")
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return len(client.ActiveConversations()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Same(t, conversation, client.ActiveConversations()[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.WaitIdle(ctx), context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, client.WaitIdle(context.Background()))
+	assert.NoError(t, <-done)
+	assert.Empty(t, client.ActiveConversations())
+}
+
+func TestChatGPT_CancelConversation(t *testing.T) {
+	cancelled := make(chan struct{})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, slowStream(t, cancelled))
+	assert.False(t, client.CancelConversation("c1"))
+
+	// a new conversation is cancelled once its id is streamed
+	conversation := client.NewConversation("", "")
+	done := make(chan error)
+	go func() {
+		_, err := conversation.SendMessage("hello")
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return client.CancelConversation("c1")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, <-done, context.Canceled)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	assert.NoError(t, client.WaitIdle(context.Background()))
+	assert.False(t, client.CancelConversation("c1"))
+}
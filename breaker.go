@@ -0,0 +1,66 @@
+package chatgpt_go
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultCircuitBreakerCooldown = 30 * time.Second
+
+// circuitBreaker stops sending requests to a failing backend. It opens
+// after threshold consecutive failures and, once cooldown has passed, lets
+// one request through: its success closes the breaker, its failure opens
+// it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns ErrCircuitOpen when a request may not be sent.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+		return fmt.Errorf("%w: retry in %s", ErrCircuitOpen, wait.Round(time.Second))
+	}
+	if b.probing {
+		return fmt.Errorf("%w: probe in flight", ErrCircuitOpen)
+	}
+	b.probing = true
+	return nil
+}
+
+// record reports the outcome of an allowed request.
+func (b *circuitBreaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// release gives up an allowed request without an outcome, e.g. because it
+// was cancelled by the caller.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
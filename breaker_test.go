@@ -0,0 +1,50 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_CircuitBreaker(t *testing.T) {
+	calls, healthy := 0, false
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  50 * time.Millisecond,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !healthy {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+
+	for i := 0; i < 2; i++ {
+		_, err := conversation.SendMessage("hello")
+		var statusErr *chatgpt_go.StatusError
+		assert.ErrorAs(t, err, &statusErr)
+	}
+	_, err := conversation.SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+
+	// The probe after the cooldown fails and opens the breaker again.
+	time.Sleep(60 * time.Millisecond)
+	_, err = conversation.SendMessage("hello")
+	assert.NotErrorIs(t, err, chatgpt_go.ErrCircuitOpen)
+	_, err = conversation.SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrCircuitOpen)
+	assert.Equal(t, 3, calls)
+
+	healthy = true
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		_, err = conversation.SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 5, calls)
+}
@@ -0,0 +1,63 @@
+package chatgpt_go
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ResponseCache stores the replies of ResponseCache-enabled clients. Keys
+// are opaque strings derived from the model, the parent message and the
+// prompt. Implementations must be safe for concurrent use.
+type ResponseCache interface {
+	Get(key string) (*ConversationResult, bool)
+	Set(key string, result *ConversationResult)
+}
+
+// cacheResponses implements ResponseCache. A reply served from the cache
+// doesn't advance the conversation, which the cached reply may not belong
+// to.
+func cacheResponses(next SendFunc) SendFunc {
+	return func(ctx context.Context, c *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		cache := c.ChatGPT.ResponseCache
+		if cache == nil || body.Action != "next" {
+			return next(ctx, c, body)
+		}
+		key := c.cacheKey(body)
+		if result, ok := cache.Get(key); ok {
+			c.ChatGPT.debug("response cache hit", map[string]interface{}{"key": key})
+			return result, nil
+		}
+		result, err := next(ctx, c, body)
+		if err != nil {
+			return result, err
+		}
+		if _, err := result.message(); err == nil {
+			cache.Set(key, result)
+		}
+		return result, nil
+	}
+}
+
+// cacheKey hashes what determines the reply to body. Prompts starting a
+// conversation share their key whatever the random parent id they get.
+func (c *Conversation) cacheKey(body *ConversationBody) string {
+	model := body.Model
+	if model == "" {
+		model = c.model()
+	}
+	parent := ""
+	if c.ConversationId != "" {
+		parent = body.ParentMessageId
+		if parent == "" {
+			parent = c.ParentMessageId
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte(model + "\x00" + parent))
+	for _, m := range body.Messages {
+		h.Write([]byte("\x00" + m.Role + "\x00" + strings.Join(m.Content.Parts, "\n")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
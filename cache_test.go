@@ -0,0 +1,59 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+type mapCache struct {
+	mu      sync.Mutex
+	results map[string]*chatgpt_go.ConversationResult
+}
+
+func (m *mapCache) Get(key string) (*chatgpt_go.ConversationResult, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.results[key]
+	return result, ok
+}
+
+func (m *mapCache) Set(key string, result *chatgpt_go.ConversationResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[key] = result
+}
+
+func TestChatGPT_ResponseCache(t *testing.T) {
+	sends := 0
+	cache := &mapCache{results: map[string]*chatgpt_go.ConversationResult{}}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{ResponseCache: cache}, func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		if sends == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["4"]}},"conversation_id":"c1"}`)
+	})
+
+	_, err := client.NewConversation("", "").SendMessage("2+2?")
+	assert.Error(t, err)
+	assert.Empty(t, cache.results)
+
+	for i := 0; i < 3; i++ {
+		conversation := client.NewConversation("", "")
+		message, err := conversation.SendMessage("2+2?")
+		assert.NoError(t, err)
+		assert.Equal(t, "4", message)
+	}
+	assert.Equal(t, 2, sends)
+
+	_, err = client.NewConversation("", "").SetModel("gpt-4").SendMessage("2+2?")
+	assert.NoError(t, err)
+	_, err = client.NewConversation("c1", "m0").SendMessage("2+2?")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, sends)
+	assert.Len(t, cache.results, 3)
+}
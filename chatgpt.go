@@ -1,26 +1,118 @@
 package chatgpt_go
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"hash/fnv"
 	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultModel             = "text-davinci-002-render"
+	defaultBaseURL           = "https://chat.openai.com"
+	defaultSessionPath       = "/api/auth/session"
+	defaultConversationPath  = "/backend-api/conversation"
+	defaultConversationsPath = "/backend-api/conversations"
+	defaultModelsPath        = "/backend-api/models"
+	defaultRefererPath       = "/chat"
+)
+
+// DefaultRolePrefix matches the role markers, such as "Assistant:", some
+// backends start replies with. See RolePrefix.
+var DefaultRolePrefix = regexp.MustCompile(`^\s*(?i:assistant|chatgpt|system)\s*:\s*`)
+
+var defaultGlitchMessages = []string{
+	"Hmm...something seems to have gone wrong.",
+	"Hmm...something seems to have gone wrong. Maybe try me again in a little bit.",
+	"Something went wrong",
+}
+
 type ChatGPT struct {
-	SessionToken       string
-	ClearanceToken     string
-	AccessToken        string
-	AccessTokenExpires time.Time
-	Log                *logrus.Entry
-	Timeout            time.Duration
-	UserAgent          string
+	SessionToken               string
+	ClearanceToken             string
+	AccessToken                string
+	AccessTokenExpires         time.Time
+	Log                        *logrus.Entry
+	Timeout                    time.Duration
+	UserAgent                  string
+	StrictModel                bool
+	PlainText                  bool
+	MaxRetries                 int
+	RetryBackoff               time.Duration
+	Metrics                    Metrics
+	BaseURL                    string
+	SessionPath                string
+	ConversationPath           string
+	ConversationsPath          string
+	ModelsPath                 string
+	RefererPath                string
+	ConversationSecret         []byte
+	DialContext                func(ctx context.Context, network, addr string) (net.Conn, error)
+	SmokeTestPrompt            string
+	SmokeTestKeep              bool
+	MaxParts                   int
+	UserAgents                 []string
+	StreamTransformer          func(delta string) string
+	TimezoneOffset             *int
+	UseLocalTimezone           bool
+	RestartLockedConversations bool
+	ModelFallbacks             []string
+	DedupeFrames               bool
+	ConversationMode           string
+	CircuitBreakerThreshold    int
+	PromptTransformer          func(prompt string) string
+	BodySerializer             func(body *ConversationBody) ([]byte, error)
+	Redactor                   func(prompt string) string
+	NullConversationId         bool
+	RetryableStatuses          []int
+	HTTPClient                 *http.Client
+	MaxRateLimitWait           time.Duration
+	StrictJSON                 bool
+	StreamBufferSize           int
+	ResponseCache              ResponseCache
+	GlitchMessages             []string
+	RolePrefix                 *regexp.Regexp
+	DrainTimeout               time.Duration
+	CorrectParentMessage       bool
+	OnParentCorrected          func(conversation *Conversation, rejected string, corrected string)
+	ValidateUserAgent          bool
+	RateLimiter                RateLimiter
+	Logger                     Logger
+	RequestInterceptor         func(req *http.Request) error
+	OnConversationID           func(conversation *Conversation, conversationId string)
+	FixInvalidJSON             bool
+
+	mu             sync.Mutex
+	refreshMu      sync.Mutex
+	tokenMu        sync.RWMutex
+	contextHeaders []contextHeader
+	middlewares    []Middleware
+	retryBudget    *retryBudget
+	breaker        *circuitBreaker
+	transport      http.RoundTripper
+	clientOnce     sync.Once
+	client         *http.Client
+	userAgentNext  uint32
+	pinnedAgents   map[string]string
+	models         []Model
+	modelsErr      error
+	modelsFailedAt time.Time
+	active         map[*Conversation]map[*activeSend]struct{}
+	idle           chan struct{}
+	now            func() time.Time
 }
 
 type ChatGPTOptions struct {
@@ -29,27 +121,389 @@ type ChatGPTOptions struct {
 	UserAgent      string
 	Log            *logrus.Entry
 	Timeout        *time.Duration
+	// StrictModel makes SendMessage return ErrModelDowngraded when the model
+	// reported in the response metadata isn't the requested one or one of
+	// its variants, e.g. "text-davinci-002-render-sha" for
+	// "text-davinci-002-render" or "gpt-4-0613" for "gpt-4".
+	StrictModel bool
+	// PlainText strips markdown formatting (code fences, emphasis, links)
+	// from the returned messages. Raw markdown is returned by default.
+	PlainText bool
+	// MaxRetries is how many times a request failing with 429, 5xx or a
+	// network error is retried. Retries are off by default.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled on every
+	// following one up to 30s and jittered, 1s by default. A Retry-After
+	// sent by the backend takes precedence, but one over 2m isn't waited
+	// for: the request fails with its *StatusError instead.
+	RetryBackoff time.Duration
+	// RetryBudget is the number of retries shared by all requests of the
+	// client, regaining one every RetryBudgetRefill (default 10 and 1s).
+	// Once it is spent requests fail fast instead of retrying.
+	RetryBudget       int
+	RetryBudgetRefill time.Duration
+	// Metrics, when set, is notified about every request and retry.
+	Metrics Metrics
+	// BaseURL is the server requests are sent to, "https://chat.openai.com"
+	// by default. SessionPath, ConversationPath, ConversationsPath (the
+	// conversation list) and ModelsPath override the endpoint paths for
+	// ChatGPT-compatible servers and must start with "/".
+	BaseURL           string
+	SessionPath       string
+	ConversationPath  string
+	ConversationsPath string
+	ModelsPath        string
+	// RefererPath is the path of the referer header sent with requests,
+	// "/chat" by default, for accounts expecting another page. The origin
+	// is always BaseURL.
+	RefererPath string
+	// ConversationSecret signs the tokens created by Conversation.Marshal so
+	// they can't be tampered with. Tokens are only encoded when it is empty.
+	ConversationSecret []byte
+	// DialContext, when set, is used by the HTTP transport to open
+	// connections, e.g. to pin chat.openai.com to an IP or resolve it with
+	// DNS-over-HTTPS.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// SmokeTestPrompt is the prompt sent by SmokeTest, "hi" by default.
+	// SmokeTestKeep keeps the conversation instead of deleting it after.
+	SmokeTestPrompt string
+	SmokeTestKeep   bool
+	// AccessToken and AccessTokenExpires seed the client with a previously
+	// fetched access token, skipping the session refresh until it expires.
+	AccessToken        string
+	AccessTokenExpires time.Time
+	// MaxParts caps the number of content parts returned from a response,
+	// unlimited when zero. It is applied once the response has been read,
+	// so it limits what callers get back, not what is read from the network.
+	MaxParts int
+	// UserAgents rotates the user agent across conversations. Each
+	// conversation keeps the same one for all its requests: new ones take
+	// the next in turn, resumed ones the one they were started with when
+	// the client or their Marshal token knows it, else one mapped from their
+	// id. UserAgent defaults to the first and is used for the session.
+	UserAgents []string
+	// StreamTransformer post-processes the reply incrementally: it is called
+	// with every piece of text added to the reply as it streams in and the
+	// reply returned is made of its outputs.
+	StreamTransformer func(delta string) string
+	// TimezoneOffset is sent as the timezone_offset_min body field, which
+	// some backends use to answer date questions. It is in minutes as
+	// returned by JavaScript's getTimezoneOffset, e.g. -480 for UTC+8.
+	// UseLocalTimezone sends the local offset when TimezoneOffset is nil.
+	// The field is omitted by default.
+	TimezoneOffset   *int
+	UseLocalTimezone bool
+	// RestartLockedConversations makes SendMessage start a new conversation,
+	// with the message as its first one, when the backend reports the
+	// conversation is locked instead of returning ErrConversationLocked.
+	// ConversationId then holds the id of the new conversation.
+	RestartLockedConversations bool
+	// ModelFallbacks are the models a message is sent to, in order, when
+	// the conversation's model is overloaded or its message cap is reached.
+	// ServedModel reports the model that answered. Off by default.
+	ModelFallbacks []string
+	// DedupeFrames skips events identical to the previous one in
+	// SendMessageFrames. The text APIs are not affected by duplicates.
+	DedupeFrames bool
+	// ConversationMode is sent as the kind of the conversation_mode body field
+	// that some accounts require, e.g. "primary_assistant", the mode of the
+	// regular ChatGPT assistant. The field is omitted when it is empty.
+	ConversationMode string
+	// CircuitBreakerThreshold opens a circuit breaker after that many
+	// consecutive requests failed with 429, 5xx or a network error: requests
+	// then fail fast with ErrCircuitOpen for CircuitBreakerCooldown (30s by
+	// default), after which a single request is let through to probe the
+	// backend. It is off when zero.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+	// PromptTransformer rewrites every user message before it is sent, by
+	// all the send methods, e.g. to prepend instructions or redact secrets
+	// across all callers. Messages are sent as-is when it is nil.
+	PromptTransformer func(prompt string) string
+	// BodySerializer, when set, encodes the conversation request bodies in
+	// place of json.Marshal, e.g. to control the order of the fields. The
+	// bytes are sent as-is: the backend may reject or misread bodies that
+	// don't match what json.Marshal produces, so use it for experiments.
+	BodySerializer func(body *ConversationBody) ([]byte, error)
+	// Redactor, when set, scrubs every user message right before it is sent,
+	// after PromptTransformer, so only the redacted form is sent and logged.
+	// RedactPII is a built-in one. Redaction is best effort: it can't be relied
+	// on to catch every piece of personal data.
+	Redactor func(prompt string) string
+	// NullConversationId sends "conversation_id": null for new conversations,
+	// which some backends expect, instead of omitting the field.
+	NullConversationId bool
+	// RetryableStatuses adds statuses to the ones retried by MaxRetries (429,
+	// 500, 502, 503 and 504), e.g. the 52x statuses of Cloudflare. IsRetryable
+	// only knows the built-in ones.
+	RetryableStatuses []int
+	// HTTPClient, when set, sends all the requests, e.g. to share a pool of
+	// connections between clients or use a proxy or custom TLS settings. Its
+	// own timeout and transport apply: Timeout and DialContext are ignored.
+	HTTPClient *http.Client
+	// MaxRateLimitWait is the longest SendMessageWaitRateLimit waits for a
+	// rate limit to be lifted, 5 minutes by default.
+	MaxRateLimitWait time.Duration
+	// StrictJSON makes reading a response fail on the first event with fields
+	// unknown to ConversationResult, to catch changes of the backend schema in
+	// development or CI. Don't enable it in production: the backend adds
+	// fields often, which would break every send.
+	StrictJSON bool
+	// StreamBufferSize is the buffer of the channel returned by StreamChannel,
+	// 16 by default.
+	StreamBufferSize int
+	// ResponseCache, when set, serves replies to prompts already answered
+	// with the same model after the same parent message, without sending
+	// them. Only cache deterministic prompts: a cached reply is returned as is,
+	// however the backend would answer now. Off by default.
+	ResponseCache ResponseCache
+	// GlitchMessages are the generic apologies the backend sometimes answers
+	// with instead of an error, e.g. "Hmm...something seems to have gone
+	// wrong.". A reply that is one of them, ignoring case, surrounding
+	// spaces and trailing punctuation, fails with ErrBackendGlitch. The English messages are detected by default; set
+	// the localized ones of the account, or an empty slice to disable it.
+	GlitchMessages []string
+	// RolePrefix, when set, is stripped from the start of replies, e.g.
+	// DefaultRolePrefix for the "Assistant:" markers some backends prepend.
+	// It only applies to a match at the very start. While streaming, the
+	// first line is held back until it is complete or 64 bytes long, so a
+	// marker is never delivered. Off by default.
+	RolePrefix *regexp.Regexp
+	// DrainTimeout, when set, keeps the connection of a send cancelled
+	// through its context while the response streams: the send returns right
+	// away and the rest of the response is drained in the background for up
+	// to DrainTimeout, so the connection can be reused. The generation then
+	// goes on until the response is complete or the timeout closes the
+	// connection. By default cancelling closes the connection, which stops
+	// the generation.
+	DrainTimeout time.Duration
+	// CorrectParentMessage recovers sends the backend rejects with
+	// ErrInvalidParent, e.g. after the conversation was edited elsewhere: the
+	// latest message of the conversation is fetched and the message is sent
+	// once more after it. OnParentCorrected, when set, is called after such a
+	// send succeeded with the rejected and the corrected parent ids.
+	CorrectParentMessage bool
+	OnParentCorrected    func(conversation *Conversation, rejected string, corrected string)
+	// ValidateUserAgent makes NewChatGPT and SetUserAgent reject user agents
+	// that don't look like a browser's, i.e. don't start with "Mozilla/".
+	// Cloudflare binds the clearance token to the exact user agent of the
+	// browser that solved the challenge, and any other one gets 403s.
+	ValidateUserAgent bool
+	// RateLimiter, when set, is waited on before every HTTP request, retries
+	// included. A nil limiter means no limiting.
+	RateLimiter RateLimiter
+	// Logger receives the debug logs of the client, e.g. through an adapter to
+	// zap or slog. While it is nil the logs go to Log, when that is set.
+	Logger Logger
+	// RequestInterceptor, when set, is called with every request just before
+	// it is sent, e.g. to inspect or redact its headers. req.GetBody returns
+	// a copy of its body. Returning an error aborts the request, which fails
+	// with it.
+	RequestInterceptor func(req *http.Request) error
+	// OnConversationID, when set, is called once per response with the
+	// conversation id, as soon as the first event carrying it is read and
+	// while the reply is still being generated, e.g. to share a link to a
+	// new conversation early. The conversation isn't updated yet at that
+	// point. It is called from the goroutine reading the response, so it
+	// must be quick.
+	OnConversationID func(conversation *Conversation, conversationId string)
+	// FixInvalidJSON makes SendJSON ask the backend once to correct a reply
+	// that isn't valid JSON. The request is a message of the conversation
+	// like any other. Off by default: ErrInvalidJSON is returned right away.
+	FixInvalidJSON bool
 }
 
 func NewChatGPT(options ChatGPTOptions) (*ChatGPT, error) {
+	options.UserAgent = strings.TrimSpace(options.UserAgent)
+	options.UserAgents = append([]string(nil), options.UserAgents...)
+	for i := range options.UserAgents {
+		options.UserAgents[i] = strings.TrimSpace(options.UserAgents[i])
+	}
+	if options.UserAgent == "" && len(options.UserAgents) > 0 {
+		options.UserAgent = options.UserAgents[0]
+	}
 	if options.SessionToken == "" || options.ClearanceToken == "" || options.UserAgent == "" {
 		return nil, fmt.Errorf("sessionToken and clearanceToken and userAgent must set")
 	}
+	for _, userAgent := range append([]string{options.UserAgent}, options.UserAgents...) {
+		if err := validateUserAgent(userAgent, options.ValidateUserAgent); err != nil {
+			return nil, err
+		}
+	}
 	c := &ChatGPT{
-		SessionToken:   options.SessionToken,
-		ClearanceToken: options.ClearanceToken,
-		UserAgent:      options.UserAgent,
-		Log:            options.Log,
-		Timeout:        0,
+		SessionToken:               options.SessionToken,
+		ClearanceToken:             options.ClearanceToken,
+		AccessToken:                options.AccessToken,
+		AccessTokenExpires:         options.AccessTokenExpires,
+		UserAgent:                  options.UserAgent,
+		Log:                        options.Log,
+		Timeout:                    0,
+		StrictModel:                options.StrictModel,
+		PlainText:                  options.PlainText,
+		MaxRetries:                 options.MaxRetries,
+		RetryBackoff:               options.RetryBackoff,
+		Metrics:                    options.Metrics,
+		BaseURL:                    strings.TrimSuffix(options.BaseURL, "/"),
+		SessionPath:                options.SessionPath,
+		ConversationPath:           options.ConversationPath,
+		ConversationsPath:          options.ConversationsPath,
+		ModelsPath:                 options.ModelsPath,
+		RefererPath:                options.RefererPath,
+		ConversationSecret:         options.ConversationSecret,
+		DialContext:                options.DialContext,
+		SmokeTestPrompt:            options.SmokeTestPrompt,
+		SmokeTestKeep:              options.SmokeTestKeep,
+		MaxParts:                   options.MaxParts,
+		UserAgents:                 options.UserAgents,
+		StreamTransformer:          options.StreamTransformer,
+		TimezoneOffset:             options.TimezoneOffset,
+		UseLocalTimezone:           options.UseLocalTimezone,
+		RestartLockedConversations: options.RestartLockedConversations,
+		ModelFallbacks:             options.ModelFallbacks,
+		DedupeFrames:               options.DedupeFrames,
+		ConversationMode:           options.ConversationMode,
+		CircuitBreakerThreshold:    options.CircuitBreakerThreshold,
+		PromptTransformer:          options.PromptTransformer,
+		BodySerializer:             options.BodySerializer,
+		Redactor:                   options.Redactor,
+		NullConversationId:         options.NullConversationId,
+		RetryableStatuses:          options.RetryableStatuses,
+		HTTPClient:                 options.HTTPClient,
+		MaxRateLimitWait:           options.MaxRateLimitWait,
+		StrictJSON:                 options.StrictJSON,
+		StreamBufferSize:           options.StreamBufferSize,
+		ResponseCache:              options.ResponseCache,
+		GlitchMessages:             options.GlitchMessages,
+		RolePrefix:                 options.RolePrefix,
+		DrainTimeout:               options.DrainTimeout,
+		CorrectParentMessage:       options.CorrectParentMessage,
+		OnParentCorrected:          options.OnParentCorrected,
+		ValidateUserAgent:          options.ValidateUserAgent,
+		RateLimiter:                options.RateLimiter,
+		Logger:                     options.Logger,
+		RequestInterceptor:         options.RequestInterceptor,
+		OnConversationID:           options.OnConversationID,
+		FixInvalidJSON:             options.FixInvalidJSON,
+	}
+	if c.BaseURL == "" {
+		c.BaseURL = defaultBaseURL
+	}
+	if c.SessionPath == "" {
+		c.SessionPath = defaultSessionPath
+	}
+	if c.ConversationPath == "" {
+		c.ConversationPath = defaultConversationPath
+	}
+	if c.ConversationsPath == "" {
+		c.ConversationsPath = defaultConversationsPath
+	}
+	if c.ModelsPath == "" {
+		c.ModelsPath = defaultModelsPath
+	}
+	if c.RefererPath == "" {
+		c.RefererPath = defaultRefererPath
+	}
+	for _, path := range []string{c.SessionPath, c.ConversationPath, c.ConversationsPath, c.ModelsPath, c.RefererPath} {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("sessionPath, conversationPath, conversationsPath, modelsPath and refererPath must start with /")
+		}
 	}
 	if options.Timeout != nil {
 		c.Timeout = *options.Timeout
 	} else {
 		c.Timeout = time.Second * 10
 	}
+	budget, refill := options.RetryBudget, options.RetryBudgetRefill
+	if budget <= 0 {
+		budget = defaultRetryBudget
+	}
+	if refill <= 0 {
+		refill = defaultRetryBudgetRefill
+	}
+	c.retryBudget = newRetryBudget(budget, refill)
+	if c.CircuitBreakerThreshold > 0 {
+		cooldown := options.CircuitBreakerCooldown
+		if cooldown <= 0 {
+			cooldown = defaultCircuitBreakerCooldown
+		}
+		c.breaker = newCircuitBreaker(c.CircuitBreakerThreshold, cooldown)
+	}
+	if c.DialContext != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = c.DialContext
+		c.transport = transport
+	}
 	return c, nil
 }
 
+type contextHeader struct {
+	key    interface{}
+	header string
+}
+
+// WithHeaderFromContext registers a context key whose value, when present in
+// the context passed to SendMessageContext, is sent as the given header.
+// This is meant for propagating trace ids into outgoing requests.
+func (c *ChatGPT) WithHeaderFromContext(key interface{}, headerName string) *ChatGPT {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contextHeaders = append(c.contextHeaders, contextHeader{key: key, header: headerName})
+	return c
+}
+
+// newRequest builds a request carrying the headers shared by every endpoint.
+func (c *ChatGPT) newRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("user-agent", c.userAgent())
+
+	// 额外的 header
+	req.Header.Set("x-openai-assistant-app-id", "")
+	req.Header.Set("accept-language", "en-US,en;q=0.9")
+	req.Header.Set("origin", c.BaseURL)
+	req.Header.Set("referer", c.BaseURL+c.RefererPath)
+
+	c.mu.Lock()
+	headers := c.contextHeaders
+	c.mu.Unlock()
+	for _, h := range headers {
+		switch v := ctx.Value(h.key).(type) {
+		case nil:
+		case string:
+			req.Header.Set(h.header, v)
+		case fmt.Stringer:
+			req.Header.Set(h.header, v.String())
+		default:
+			req.Header.Set(h.header, fmt.Sprint(v))
+		}
+	}
+	return req, nil
+}
+
+// setAuthHeaders sets the access token and clearance cookie used by the
+// backend-api endpoints.
+func (c *ChatGPT) setAuthHeaders(req *http.Request) {
+	c.tokenMu.RLock()
+	token, clearance := c.AccessToken, c.ClearanceToken
+	c.tokenMu.RUnlock()
+	req.Header.Set("authorization", token)
+	req.Header.Set("cookie", fmt.Sprintf("cf_clearance=%s", clearance))
+}
+
+func (c *ChatGPT) timezoneOffset() *int {
+	if c.TimezoneOffset != nil {
+		return c.TimezoneOffset
+	}
+	if c.UseLocalTimezone {
+		_, offset := time.Now().Zone()
+		minutes := -offset / 60
+		return &minutes
+	}
+	return nil
+}
+
 type SessionResult struct {
 	User struct {
 		Id       string        `json:"id"`
@@ -66,59 +520,101 @@ type SessionResult struct {
 }
 
 func (c *ChatGPT) IsAccessTokenExpired() bool {
-	return time.Now().After(c.AccessTokenExpires)
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.clock().After(c.AccessTokenExpires)
+}
+
+// tokenValid reports whether there is an access token that hasn't expired.
+func (c *ChatGPT) tokenValid() bool {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.AccessToken != "" && !c.clock().After(c.AccessTokenExpires)
 }
 
 func (c *ChatGPT) RefreshAccessToken() error {
-	if c.AccessToken == "" || c.IsAccessTokenExpired() {
-		req, err := http.NewRequest(http.MethodGet, "https://chat.openai.com/api/auth/session", nil)
-		if err != nil {
-			return err
-		}
-		req.Header.Set("cookie", fmt.Sprintf("cf_clearance=%s; __Secure-next-auth.session-token=%s", c.ClearanceToken, c.SessionToken))
-		req.Header.Set("user-agent", c.UserAgent)
+	return c.RefreshAccessTokenContext(context.Background())
+}
 
-		// 额外的 header
-		req.Header.Set("x-openai-assistant-app-id", "")
-		req.Header.Set("accept-language", "en-US,en;q=0.9")
-		req.Header.Set("origin", "https://chat.openai.com")
-		req.Header.Set("referer", "https://chat.openai.com/chat")
+// RefreshAccessTokenContext is RefreshAccessToken with a context cancelling
+// the session request.
+//
+// It is safe for concurrent use: concurrent callers wait for a single
+// session request and share the token it returns.
+func (c *ChatGPT) RefreshAccessTokenContext(ctx context.Context) error {
+	if c.tokenValid() {
+		return nil
+	}
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.refreshAccessToken(ctx, false)
+}
 
-		resp, err := (&http.Client{Timeout: c.Timeout}).Do(req)
+// refreshAccessToken fetches a new access token from the session endpoint
+// when the current one is missing or expired, or always when force is set.
+// The caller holds refreshMu.
+func (c *ChatGPT) refreshAccessToken(ctx context.Context, force bool) error {
+	if !force && c.tokenValid() {
+		return nil
+	}
+	if err := c.fetchAccessToken(ctx); err != nil {
+		emitAuthEvent(AuthEvent{Type: AuthFailed, Client: c, Err: err})
+		return err
+	}
+	emitAuthEvent(AuthEvent{Type: TokenRefreshed, Client: c})
+	return nil
+}
 
-		if err != nil {
-			if c.Log != nil {
-				c.Log.WithError(err).Debug("GET https://chat.openai.com/api/auth/session error")
-			}
-			return err
-		}
+// fetchAccessToken gets a new access token from the session endpoint.
+func (c *ChatGPT) fetchAccessToken(ctx context.Context) error {
+	url := c.BaseURL + c.SessionPath
+	req, err := c.newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	c.tokenMu.RLock()
+	clearance, session := c.ClearanceToken, c.SessionToken
+	c.tokenMu.RUnlock()
+	req.Header.Set("cookie", fmt.Sprintf("cf_clearance=%s; __Secure-next-auth.session-token=%s", clearance, session))
 
-		b, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("read body: %w", err)
-		}
+	resp, err := c.do(endpointSession, req)
 
-		if c.Log != nil {
-			c.Log.WithFields(logrus.Fields{"status_code": resp.StatusCode, "body": string(b)}).Debug("GET https://chat.openai.com/api/auth/session success")
-		}
+	if err != nil {
+		c.debug("GET "+url+" error", map[string]interface{}{"error": err})
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("response status=%d not 200", resp.StatusCode)
-		}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
 
-		respJson := SessionResult{}
-		if err := json.Unmarshal(b, &respJson); err != nil {
-			return fmt.Errorf("JSON %s format: %w", string(b), err)
-		}
-		if respJson.AccessToken == "" {
-			return fmt.Errorf("response not containes accessToken: %s", string(b))
-		}
-		if respJson.Error != "" {
-			return fmt.Errorf("response has error: %s", respJson.Error)
+	c.debug("GET "+url+" success", map[string]interface{}{"status_code": resp.StatusCode, "body": string(b)})
+
+	if resp.StatusCode != http.StatusOK {
+		e := responseError(resp, b)
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && e.err != ErrCloudflareChallenge {
+			e.err = ErrSessionExpired
 		}
-		c.AccessTokenExpires = respJson.Expires
-		c.AccessToken = respJson.AccessToken
+		return e
+	}
+
+	respJson := SessionResult{}
+	if err := json.Unmarshal(b, &respJson); err != nil {
+		return fmt.Errorf("JSON %s format: %w", string(b), err)
+	}
+	if respJson.Error != "" {
+		return fmt.Errorf("%w: response has error: %s", ErrSessionExpired, respJson.Error)
+	}
+	if respJson.AccessToken == "" {
+		// the session endpoint answers {} to unknown session tokens
+		return fmt.Errorf("%w: response not containes accessToken: %s", ErrSessionExpired, string(b))
 	}
+	c.tokenMu.Lock()
+	c.AccessTokenExpires = respJson.Expires
+	c.AccessToken = respJson.AccessToken
+	c.tokenMu.Unlock()
 	return nil
 }
 
@@ -126,6 +622,64 @@ type Conversation struct {
 	ChatGPT         *ChatGPT
 	ConversationId  string
 	ParentMessageId string
+	// Model is the slug of the model messages are sent to, e.g. "gpt-4".
+	// When empty the model that served the last response is used, and
+	// text-davinci-002-render for the first message.
+	Model string
+	// ExtraBodyFields are merged into every request body sent on the
+	// conversation, e.g. experimental flags like "force_paragen". Unknown
+	// fields are sent as-is; they never override the regular fields.
+	ExtraBodyFields map[string]interface{}
+
+	servedModel     string
+	userAgent       string
+	estimatedTokens int
+	// lastPrompt is the last message sent and lastPromptParent the message
+	// it answered, for Regenerate.
+	lastPrompt       *ConversationBodyMessage
+	lastPromptParent string
+	// lastOutput is the text of the last reply as returned to the caller,
+	// continuations included, and truncated whether it was cut at the
+	// length limit.
+	lastOutput string
+	truncated  bool
+}
+
+// IsNew reports whether the conversation has no id yet, i.e. no message
+// was answered on it: the next message starts a new conversation.
+func (c *Conversation) IsNew() bool {
+	return c.ConversationId == ""
+}
+
+// Truncated reports whether the last reply received on the conversation was
+// cut at the length limit, in which case the rest can be generated with
+// ContinueGeneration.
+func (c *Conversation) Truncated() bool {
+	return c.truncated
+}
+
+// ServedModel returns the model slug the backend reported for the last
+// response. Later sends on the conversation default to this model unless
+// Model is set.
+func (c *Conversation) ServedModel() string {
+	return c.servedModel
+}
+
+// SetModel sets the model messages are sent to and returns the
+// conversation.
+func (c *Conversation) SetModel(model string) *Conversation {
+	c.Model = model
+	return c
+}
+
+func (c *Conversation) model() string {
+	if c.Model != "" {
+		return c.Model
+	}
+	if c.servedModel != "" {
+		return c.servedModel
+	}
+	return defaultModel
 }
 
 func (c *ChatGPT) NewConversation(conversationId string, parentMessageId string) *Conversation {
@@ -133,12 +687,110 @@ func (c *ChatGPT) NewConversation(conversationId string, parentMessageId string)
 		ChatGPT:         c,
 		ConversationId:  conversationId,
 		ParentMessageId: parentMessageId,
+		userAgent:       c.conversationUserAgent(conversationId),
+	}
+}
+
+// SetUserAgent replaces the user agent sent with every request, e.g. along
+// with a clearance token solved in another browser. The user agent is
+// trimmed and checked as in NewChatGPT. Conversations rotating through
+// UserAgents keep their own.
+func (c *ChatGPT) SetUserAgent(userAgent string) error {
+	userAgent = strings.TrimSpace(userAgent)
+	if err := validateUserAgent(userAgent, c.ValidateUserAgent); err != nil {
+		return err
+	}
+	c.tokenMu.Lock()
+	c.UserAgent = userAgent
+	c.tokenMu.Unlock()
+	return nil
+}
+
+func (c *ChatGPT) userAgent() string {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.UserAgent
+}
+
+// validateUserAgent rejects user agents that can't be sent as a header and,
+// when strict, the ones that don't look like a browser's.
+func validateUserAgent(userAgent string, strict bool) error {
+	if userAgent == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidUserAgent)
+	}
+	for _, r := range userAgent {
+		if r < ' ' || r == 0x7f {
+			return fmt.Errorf("%w: control character in %q", ErrInvalidUserAgent, userAgent)
+		}
+	}
+	if strict && !strings.HasPrefix(userAgent, "Mozilla/") {
+		return fmt.Errorf("%w: %q is not a browser user agent", ErrInvalidUserAgent, userAgent)
+	}
+	return nil
+}
+
+// maxPinnedUserAgents caps how many conversations the client remembers the
+// user agent of.
+const maxPinnedUserAgents = 10000
+
+// conversationUserAgent picks the user agent of a conversation from
+// UserAgents. It is empty without rotation: the client's user agent is used.
+// A conversation started by the client keeps the agent it was started with;
+// others are mapped to one by their id.
+func (c *ChatGPT) conversationUserAgent(conversationId string) string {
+	if len(c.UserAgents) == 0 {
+		return ""
+	}
+	if conversationId != "" {
+		c.mu.Lock()
+		userAgent, ok := c.pinnedAgents[conversationId]
+		c.mu.Unlock()
+		if ok {
+			return userAgent
+		}
 	}
+	var n uint32
+	if conversationId != "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(conversationId))
+		n = h.Sum32()
+	} else {
+		n = atomic.AddUint32(&c.userAgentNext, 1) - 1
+	}
+	return c.UserAgents[n%uint32(len(c.UserAgents))]
+}
+
+// pinUserAgent remembers the user agent a conversation was started with, so
+// that resuming it by id keeps sending it.
+func (c *ChatGPT) pinUserAgent(conversationId string, userAgent string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pinnedAgents == nil {
+		c.pinnedAgents = map[string]string{}
+	}
+	if _, ok := c.pinnedAgents[conversationId]; !ok && len(c.pinnedAgents) >= maxPinnedUserAgents {
+		for id := range c.pinnedAgents {
+			delete(c.pinnedAgents, id)
+			break
+		}
+	}
+	c.pinnedAgents[conversationId] = userAgent
+}
+
+// knownUserAgent reports whether userAgent is one of UserAgents.
+func (c *ChatGPT) knownUserAgent(userAgent string) bool {
+	for _, ua := range c.UserAgents {
+		if ua == userAgent {
+			return true
+		}
+	}
+	return false
 }
 
 type ConversationBodyMessage struct {
-	Id      string `json:"id"`
-	Role    string `json:"role"`
+	Id      string         `json:"id"`
+	Role    string         `json:"role"`
+	Author  *MessageAuthor `json:"author,omitempty"`
 	Content struct {
 		ContentType string   `json:"content_type"`
 		Parts       []string `json:"parts"`
@@ -146,11 +798,60 @@ type ConversationBodyMessage struct {
 }
 
 type ConversationBody struct {
-	Action          string                    `json:"action"`
-	Messages        []ConversationBodyMessage `json:"messages"`
-	ParentMessageId string                    `json:"parent_message_id"`
-	Model           string                    `json:"model"`
-	ConversationId  string                    `json:"conversation_id,omitempty"`
+	Action           string                    `json:"action"`
+	Messages         []ConversationBodyMessage `json:"messages,omitempty"`
+	ParentMessageId  string                    `json:"parent_message_id"`
+	Model            string                    `json:"model"`
+	ConversationId   string                    `json:"conversation_id,omitempty"`
+	TimezoneOffset   *int                      `json:"timezone_offset_min,omitempty"`
+	ConversationMode *ConversationMode         `json:"conversation_mode,omitempty"`
+	// HistoryAndTrainingDisabled keeps the conversation out of the history
+	// and model training. PluginIds are the plugins the model may use.
+	HistoryAndTrainingDisabled bool     `json:"history_and_training_disabled,omitempty"`
+	PluginIds                  []string `json:"plugin_ids,omitempty"`
+
+	ExtraFields map[string]interface{} `json:"-"`
+	// NullConversationId sends conversation_id as null when it is empty.
+	NullConversationId bool `json:"-"`
+}
+
+// MessageAuthor is the author of a message. Backends that support it use
+// Name to tell apart the speakers of a conversation.
+type MessageAuthor struct {
+	Role string `json:"role"`
+	Name string `json:"name,omitempty"`
+}
+
+// ConversationMode is the conversation_mode body field.
+type ConversationMode struct {
+	Kind string `json:"kind"`
+}
+
+func (b ConversationBody) MarshalJSON() ([]byte, error) {
+	type body ConversationBody
+	bs, err := json.Marshal(body(b))
+	nullId := b.NullConversationId && b.ConversationId == ""
+	if err != nil || (len(b.ExtraFields) == 0 && !nullId) {
+		return bs, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		return nil, err
+	}
+	if nullId {
+		fields["conversation_id"] = json.RawMessage("null")
+	}
+	for k, v := range b.ExtraFields {
+		if _, ok := fields[k]; ok {
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("marshal extra field %s: %w", k, err)
+		}
+		fields[k] = raw
+	}
+	return json.Marshal(fields)
 }
 
 type ConversationResult struct {
@@ -164,18 +865,198 @@ type ConversationResult struct {
 			ContentType string   `json:"content_type"`
 			Parts       []string `json:"parts"`
 		} `json:"content"`
-		EndTurn  interface{} `json:"end_turn"`
-		Weight   float64     `json:"weight"`
-		Metadata struct {
-		} `json:"metadata"`
-		Recipient string `json:"recipient"`
+		EndTurn   interface{}     `json:"end_turn"`
+		Weight    float64         `json:"weight"`
+		Metadata  MessageMetadata `json:"metadata"`
+		Recipient string          `json:"recipient"`
 	} `json:"message"`
-	ConversationId string      `json:"conversation_id"`
-	Error          interface{} `json:"error"`
+	ConversationId     string      `json:"conversation_id"`
+	Error              interface{} `json:"error"`
+	Type               string      `json:"type,omitempty"`
+	MessageId          string      `json:"message_id,omitempty"`
+	ModerationResponse *Moderation `json:"moderation_response,omitempty"`
+
+	plainText      bool
+	maxParts       int
+	glitchMessages []string
+	rolePrefix     *regexp.Regexp
+	raw            []byte
+}
+
+// MessageMetadata is the metadata the backend sends with a message.
+// FinishDetails tells whether the reply is complete or was cut at the
+// length limit.
+type MessageMetadata struct {
+	ModelSlug     string         `json:"model_slug"`
+	FinishDetails *FinishDetails `json:"finish_details,omitempty"`
+	MessageType   string         `json:"message_type,omitempty"`
+	IsComplete    bool           `json:"is_complete,omitempty"`
+	// Usage holds the token counts of the exchange when the backend
+	// reports them, which it rarely does.
+	Usage *TokenUsage `json:"usage,omitempty"`
+}
+
+// TokenUsage is the token count of an exchange.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// FinishDetails tells why the backend stopped generating a message: Type
+// is "stop" when it was done and "max_tokens" when it hit the length limit.
+type FinishDetails struct {
+	Type string `json:"type"`
+	Stop string `json:"stop,omitempty"`
+}
+
+// Moderation is the moderation verdict the backend streams for flagged
+// prompts or replies.
+type Moderation struct {
+	Flagged      bool     `json:"flagged"`
+	Blocked      bool     `json:"blocked"`
+	ModerationId string   `json:"moderation_id"`
+	Categories   []string `json:"categories,omitempty"`
+}
+
+// Moderation returns the moderation verdict received with the response, or
+// nil when the response wasn't moderated.
+func (r *ConversationResult) Moderation() *Moderation {
+	return r.ModerationResponse
+}
+
+// EndTurn reports whether the backend marked the message as ending its
+// turn. It is false for a reply that was cut short and can be continued
+// with ContinueGeneration, and when the backend didn't send the flag.
+func (r *ConversationResult) EndTurn() bool {
+	endTurn, _ := r.Message.EndTurn.(bool)
+	return endTurn
+}
+
+// Truncated reports whether the message was cut at the length limit, in
+// which case the rest can be generated with ContinueGeneration.
+func (r *ConversationResult) Truncated() bool {
+	return r.Message.Metadata.FinishDetails != nil && r.Message.Metadata.FinishDetails.Type == "max_tokens"
+}
+
+// CreateTime returns the time the backend created the message, the zero
+// time when it wasn't sent.
+func (r *ConversationResult) CreateTime() time.Time {
+	return unixTime(r.Message.CreateTime)
+}
+
+// UpdateTime returns the time the backend last updated the message, the
+// zero time when it wasn't sent, as is common on the first events.
+func (r *ConversationResult) UpdateTime() time.Time {
+	return unixTime(r.Message.UpdateTime)
+}
+
+// unixTime converts a timestamp in (fractional) seconds since the epoch,
+// or an RFC 3339 string as used by some endpoints.
+func unixTime(v interface{}) time.Time {
+	if s, ok := v.(string); ok {
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	}
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+	whole := math.Floor(seconds)
+	return time.Unix(int64(whole), int64((seconds-whole)*1e9))
+}
+
+// text returns the raw content of the message so far, its parts joined
+// with newlines, empty when the event carries no content.
+func (r *ConversationResult) text() string {
+	parts, _ := r.MessageParts()
+	return strings.Join(parts, "\n")
+}
+
+// MessageParts returns the content parts of the message, capped to the
+// client's MaxParts. The bool reports whether parts were dropped.
+func (r *ConversationResult) MessageParts() ([]string, bool) {
+	parts := r.Message.Content.Parts
+	if r.maxParts > 0 && len(parts) > r.maxParts {
+		return parts[:r.maxParts], true
+	}
+	return parts, false
+}
+
+// glitchMessages returns GlitchMessages, or the default ones when unset.
+func (c *ChatGPT) glitchMessages() []string {
+	if c.GlitchMessages == nil {
+		return defaultGlitchMessages
+	}
+	return c.GlitchMessages
+}
+
+// message is GetMessage for the final result of a send: it returns
+// ErrNoContent, with the raw frame, when the result carries no content,
+// telling when moderation flagged it, and ErrBackendGlitch when it is one
+// of the GlitchMessages.
+func (r *ConversationResult) message() (string, error) {
+	blocked := r.ModerationResponse != nil && r.ModerationResponse.Blocked
+	if !blocked && len(r.Message.Content.Parts) == 0 {
+		if r.ModerationResponse != nil && r.ModerationResponse.Flagged {
+			return "", fmt.Errorf("%w: flagged by moderation, the response ended early: %s", ErrNoContent, r.raw)
+		}
+		return "", fmt.Errorf("%w: %s", ErrNoContent, r.raw)
+	}
+	text := stripRolePrefix(r.rolePrefix, r.text())
+	for _, glitch := range r.glitchMessages {
+		if glitch != "" && normalizeGlitch(text) == normalizeGlitch(glitch) {
+			return "", fmt.Errorf("%w: %s", ErrBackendGlitch, text)
+		}
+	}
+	return r.GetMessage()
+}
+
+// normalizeGlitch returns text lowercased, without surrounding spaces and
+// trailing punctuation, to compare replies with the GlitchMessages.
+func normalizeGlitch(text string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(text), ".!…"))
 }
 
+// GetMessage returns the text of the message, its parts joined with
+// newlines. Only the first MaxParts parts are included when the client
+// caps them; GetFullMessage includes all of them. ErrContentBlocked is
+// returned when moderation blocked it and ErrNoContent when it has no
+// parts, e.g. for error payloads.
 func (r *ConversationResult) GetMessage() (string, error) {
-	return r.Message.Content.Parts[0], nil
+	return r.joinParts(r.text())
+}
+
+// GetFullMessage returns the text of the message like GetMessage, but with
+// all its parts whatever MaxParts, e.g. for code blocks or plugin output
+// the backend split across many parts.
+func (r *ConversationResult) GetFullMessage() (string, error) {
+	return r.joinParts(strings.Join(r.Message.Content.Parts, "\n"))
+}
+
+func (r *ConversationResult) joinParts(text string) (string, error) {
+	if r.ModerationResponse != nil && r.ModerationResponse.Blocked {
+		return "", ErrContentBlocked
+	}
+	if len(r.Message.Content.Parts) == 0 {
+		return "", fmt.Errorf("%w: message %q has no content parts", ErrNoContent, r.Message.Id)
+	}
+	text = stripRolePrefix(r.rolePrefix, text)
+	if r.plainText {
+		return StripMarkdown(text), nil
+	}
+	return text, nil
+}
+
+// stripRolePrefix removes the match of re at the start of text.
+func stripRolePrefix(re *regexp.Regexp, text string) string {
+	if re == nil {
+		return text
+	}
+	if loc := re.FindStringIndex(text); loc != nil && loc[0] == 0 {
+		return text[loc[1]:]
+	}
+	return text
 }
 
 func (r *ConversationResult) JSON() []byte {
@@ -183,6 +1064,14 @@ func (r *ConversationResult) JSON() []byte {
 	return bs
 }
 
+// marshalBody encodes body with the BodySerializer, or json.Marshal.
+func (c *ChatGPT) marshalBody(body *ConversationBody) ([]byte, error) {
+	if c.BodySerializer != nil {
+		return c.BodySerializer(body)
+	}
+	return json.Marshal(body)
+}
+
 func (b *ConversationBody) Reader() (io.Reader, error) {
 	bs, err := json.Marshal(b)
 	if err != nil {
@@ -196,14 +1085,64 @@ func (b *ConversationBody) JSON() []byte {
 	return bs
 }
 
+// SendMessage sends message on the conversation and returns the reply. It is
+// SendMessageContext with context.Background().
 func (c *Conversation) SendMessage(message string) (string, error) {
-	if c.ParentMessageId == "" {
-		c.ParentMessageId = uuid.NewString()
+	return c.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext sends message on the conversation and returns the
+// reply. Cancelling ctx aborts the request, even while the reply is being
+// streamed, and returns ctx's error; the conversation isn't advanced then.
+func (c *Conversation) SendMessageContext(ctx context.Context, message string) (string, error) {
+	return c.sendMessage(ctx, c.nextBody(message))
+}
+
+// sendMessage sends body and returns the reply text.
+func (c *Conversation) sendMessage(ctx context.Context, body *ConversationBody) (string, error) {
+	if c.ChatGPT.StreamTransformer != nil {
+		_, text, err := c.streamText(ctx, body, nil)
+		if err != nil {
+			return "", err
+		}
+		return text, nil
+	}
+	result, err := c.sendFull(ctx, body)
+	if err != nil {
+		return "", err
+	}
+	return result.GetMessage()
+}
+
+// SendMessageFull sends message like SendMessage and returns the whole
+// parsed response, with the message id, metadata and conversation id, for
+// callers that need more than the text. The conversation is advanced the
+// same way. The result is also returned along with ErrContentBlocked,
+// ErrNoContent and ErrModelDowngraded so it can be inspected.
+func (c *Conversation) SendMessageFull(message string) (*ConversationResult, error) {
+	return c.sendFull(context.Background(), c.nextBody(message))
+}
+
+func (c *Conversation) sendFull(ctx context.Context, body *ConversationBody) (*ConversationResult, error) {
+	result, err := c.send(ctx, body, streamHandler{})
+	if err != nil {
+		return result, err
+	}
+	if _, err := result.message(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// nextBody builds the body posting message as a new user message.
+func (c *Conversation) nextBody(message string) *ConversationBody {
+	if c.ChatGPT.PromptTransformer != nil {
+		message = c.ChatGPT.PromptTransformer(message)
 	}
-	if err := c.ChatGPT.RefreshAccessToken(); err != nil {
-		return "", fmt.Errorf("refresh access token: %w", err)
+	if c.ChatGPT.Redactor != nil {
+		message = c.ChatGPT.Redactor(message)
 	}
-	body := ConversationBody{
+	return &ConversationBody{
 		Action: "next",
 		Messages: []ConversationBodyMessage{{
 			Id:   uuid.NewString(),
@@ -216,84 +1155,313 @@ func (c *Conversation) SendMessage(message string) (string, error) {
 				Parts:       []string{message},
 			},
 		}},
-		ParentMessageId: c.ParentMessageId,
-		Model:           "text-davinci-002-render",
 	}
-	if c.ConversationId != "" {
-		body.ConversationId = c.ConversationId
-	}
-	bodyReader, err := body.Reader()
-	if c.ChatGPT.Log != nil {
-		c.ChatGPT.Log.WithField("body", string(body.JSON())).Debug("send_request")
+}
+
+// send posts body to the conversation and reads the response stream with
+// h, through the middlewares registered with Use. The parent message,
+// model and conversation id are taken from the conversation unless
+// already set.
+func (c *Conversation) send(ctx context.Context, body *ConversationBody, h streamHandler) (*ConversationResult, error) {
+	ctx, untrack := c.ChatGPT.track(ctx, c)
+	defer untrack()
+
+	next := SendFunc(func(ctx context.Context, conversation *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		return conversation.sendOnce(ctx, body, h)
+	})
+	next = cacheResponses(fallbackModels(restartLocked(correctParent(refreshToken(retrySends(next))))))
+	c.ChatGPT.mu.Lock()
+	middlewares := c.ChatGPT.middlewares
+	c.ChatGPT.mu.Unlock()
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next = middlewares[i](next)
 	}
+	return next(ctx, c, body)
+}
+
+func (c *Conversation) sendOnce(ctx context.Context, body *ConversationBody, h streamHandler) (*ConversationResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := c.buildRequest(ctx, body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, "https://chat.openai.com/backend-api/conversation", bodyReader)
-	if err != nil {
-		return "", err
+	var resp *http.Response
+	if c.ChatGPT.DrainTimeout > 0 {
+		resp, err = c.ChatGPT.doDrained(ctx, endpointConversation, req)
+	} else {
+		resp, err = c.ChatGPT.do(endpointConversation, req)
 	}
-	req.Header.Set("authorization", c.ChatGPT.AccessToken)
-	req.Header.Set("content-type", "application/json")
-	req.Header.Set("user-agent", c.ChatGPT.UserAgent)
-	req.Header.Set("accept", "text/event-stream")
-	req.Header.Set("cookie", fmt.Sprintf("cf_clearance=%s", c.ChatGPT.ClearanceToken))
-
-	req.Header.Set("x-openai-assistant-app-id", "")
-	req.Header.Set("accept-language", "en-US,en;q=0.9")
-	req.Header.Set("origin", "https://chat.openai.com")
-	req.Header.Set("referer", "https://chat.openai.com/chat")
-	resp, err := (&http.Client{Timeout: c.ChatGPT.Timeout}).Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("response status code=%d, body=%s", resp.StatusCode, string(body))
+		return nil, responseError(resp, body)
+	}
+	if attempt, ok := ctx.Value(sendAttemptKey{}).(*sendAttempt); ok {
+		attempt.streamed = true
 	}
 
-	respMessage := ""
-	br := bufio.NewReader(resp.Body)
-	defer func() { _ = resp.Body.Close() }()
-	delim := []byte{':', ' '}
+	result, err := c.readResult(resp, h)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	for {
-		bs, err := br.ReadBytes('\n')
+	c.ChatGPT.debug("send_response", map[string]interface{}{"body": string(result.JSON())})
 
-		if err != nil && err != io.EOF {
-			return "", err
+	if body.Action == "next" && len(body.Messages) > 0 {
+		c.lastPrompt = &body.Messages[len(body.Messages)-1]
+		c.lastPromptParent = body.ParentMessageId
+	}
+	// a failed reply, e.g. a glitch, isn't continued from: the conversation
+	// goes on from the previous one
+	text, replyErr := result.message()
+	if result.Message.Id != "" && replyErr == nil {
+		c.ParentMessageId = result.Message.Id
+	}
+	if result.ConversationId != "" {
+		if result.ConversationId != c.ConversationId && c.userAgent != "" {
+			c.ChatGPT.pinUserAgent(result.ConversationId, c.userAgent)
 		}
+		c.ConversationId = result.ConversationId
+	}
 
-		if len(bs) < 2 {
-			continue
+	if served := result.Message.Metadata.ModelSlug; served != "" {
+		if c.ChatGPT.StrictModel && !servedAs(body.Model, served) {
+			return result, fmt.Errorf("%w: requested %s, served %s", ErrModelDowngraded, body.Model, served)
+		}
+		c.servedModel = served
+	}
+	c.countTokens(body, result)
+	if replyErr == nil {
+		if body.Action == "continue" {
+			c.lastOutput += text
+		} else {
+			c.lastOutput = text
 		}
+		c.truncated = result.Truncated()
+	}
 
-		spl := bytes.SplitN(bs, delim, 2)
+	return result, nil
+}
 
-		if len(spl) < 2 {
-			continue
-		}
+// servedAs reports whether the backend serving model served is acceptable
+// for the requested one: the same model or a variant of it, which the
+// backend names after it with a suffix.
+func servedAs(requested string, served string) bool {
+	return served == requested || strings.HasPrefix(served, requested+"-")
+}
 
-		value := strings.TrimSuffix(string(spl[1]), "\n")
+// buildRequest fills body from the conversation and builds the request
+// posting it with the current access token.
+func (c *Conversation) buildRequest(ctx context.Context, body *ConversationBody) (*http.Request, error) {
+	if c.ParentMessageId == "" {
+		c.ParentMessageId = uuid.NewString()
+	}
+	if body.ParentMessageId == "" {
+		body.ParentMessageId = c.ParentMessageId
+	}
+	if body.Model == "" {
+		body.Model = c.model()
+	}
+	if body.TimezoneOffset == nil {
+		body.TimezoneOffset = c.ChatGPT.timezoneOffset()
+	}
+	if body.ConversationMode == nil && c.ChatGPT.ConversationMode != "" {
+		body.ConversationMode = &ConversationMode{Kind: c.ChatGPT.ConversationMode}
+	}
+	body.ExtraFields = c.ExtraBodyFields
+	body.NullConversationId = c.ChatGPT.NullConversationId
+	if c.ConversationId != "" {
+		body.ConversationId = c.ConversationId
+	}
+	bs, err := c.ChatGPT.marshalBody(body)
+	if err != nil {
+		return nil, err
+	}
+	c.ChatGPT.debug("send_request", map[string]interface{}{"body": string(bs)})
+	req, err := c.ChatGPT.newRequest(ctx, http.MethodPost, c.ChatGPT.BaseURL+c.ChatGPT.ConversationPath, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	c.ChatGPT.setAuthHeaders(req)
+	if c.userAgent != "" {
+		req.Header.Set("user-agent", c.userAgent)
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("accept", "text/event-stream")
+	return req, nil
+}
 
-		if err == io.EOF || value == "[DONE]" {
-			break
-		}
-		respMessage = value
+// decodeFrame parses an event of the response stream, rejecting unknown
+// fields when StrictJSON is set.
+func (c *ChatGPT) decodeFrame(data []byte, frame *ConversationResult) error {
+	if !c.StrictJSON {
+		return json.Unmarshal(data, frame)
 	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(frame)
+}
 
-	result := ConversationResult{}
-	if err := json.Unmarshal([]byte(respMessage), &result); err != nil {
-		return "", err
+// response kinds told apart by responseKind
+const (
+	responseEventStream = iota
+	responseJSON
+	responseHTML
+)
+
+// responseKind tells how to read a conversation response from its
+// content-type: the backend answers with an event stream normally, a single
+// JSON object from some proxies and non-streaming servers, and the HTML
+// login page when the session is no longer valid. Responses without or
+// with another content-type are read as event streams.
+func responseKind(resp *http.Response) int {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("content-type"))
+	switch mediaType {
+	case "application/json":
+		return responseJSON
+	case "text/html":
+		return responseHTML
+	}
+	return responseEventStream
+}
+
+// errorMessage returns the message of the error field of an event, given
+// either as a string or as an object such as {"message":"...","code":"..."}.
+func errorMessage(v interface{}) string {
+	switch e := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return e
+	case map[string]interface{}:
+		for _, key := range []string{"message", "detail", "code"} {
+			if s, ok := e[key].(string); ok && s != "" {
+				return s
+			}
+		}
 	}
+	bs, _ := json.Marshal(v)
+	return string(bs)
+}
 
-	if c.ChatGPT.Log != nil {
-		c.ChatGPT.Log.WithField("body", string(result.JSON())).Debug("send_response")
+// readResult reads the event stream of a conversation response and returns
+// its last event. Events that fail to parse are skipped. A JSON response is
+// read as a stream of a single event.
+func (c *Conversation) readResult(resp *http.Response, h streamHandler) (*ConversationResult, error) {
+	if resp.Body == nil {
+		return nil, ErrEmptyResponse
 	}
+	defer func() { _ = resp.Body.Close() }()
 
-	c.ParentMessageId = result.Message.Id
-	c.ConversationId = result.ConversationId
+	kind := responseKind(resp)
+	if kind == responseHTML {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%w: got html page: %s", ErrLoginRequired, string(snippet))
+	}
 
-	return result.GetMessage()
+	var (
+		result     *ConversationResult
+		moderation *ConversationResult
+		parseErr   error
+		streamErr  error
+		complete   bool
+		notified   bool
+	)
+	ctx := context.Background()
+	if resp.Request != nil {
+		ctx = resp.Request.Context()
+	}
+	stats, _ := ctx.Value(streamStatsKey{}).(*streamStats)
+	handle := func(data []byte) error {
+		frame := &ConversationResult{plainText: c.ChatGPT.PlainText, maxParts: c.ChatGPT.MaxParts, glitchMessages: c.ChatGPT.glitchMessages(), rolePrefix: c.ChatGPT.RolePrefix}
+		err := c.ChatGPT.decodeFrame(data, frame)
+		if err == nil && !notified && frame.ConversationId != "" {
+			notified = true
+			c.ChatGPT.trackConversationId(ctx, frame.ConversationId)
+			if c.ChatGPT.OnConversationID != nil {
+				c.ChatGPT.OnConversationID(c, frame.ConversationId)
+			}
+		}
+		if err != nil {
+			// raw frame consumers interpret the events themselves
+			if h.onFrame != nil {
+				frame = nil
+			} else if c.ChatGPT.StrictJSON {
+				return fmt.Errorf("decode event %s: %w", data, err)
+			} else {
+				parseErr = err
+				frame = nil
+			}
+		} else if frame.ModerationResponse != nil && frame.Message.Id == "" {
+			frame.raw = data
+			moderation = frame
+			frame = nil
+		} else if msg := errorMessage(frame.Error); msg != "" && len(frame.Message.Content.Parts) == 0 {
+			streamErr = fmt.Errorf("%w: %s", ErrStreamError, msg)
+			frame = nil
+		} else if frame.Type == "message_stream_complete" {
+			// newer backends end the stream with this event instead of [DONE]
+			if result != nil && result.ConversationId == "" {
+				result.ConversationId = frame.ConversationId
+			}
+			complete = true
+			frame = nil
+		} else {
+			frame.raw = data
+			if stats != nil {
+				stats.observe(frame.text())
+			}
+			if frame.Message.CreateTime == nil && result != nil && result.Message.Id == frame.Message.Id {
+				frame.Message.CreateTime = result.Message.CreateTime
+			}
+			result = frame
+		}
+		if h.onFrame != nil && !h.onFrame(data) {
+			return errStopStream
+		}
+		if frame != nil && h.onResult != nil && !h.onResult(frame) {
+			return errStopStream
+		}
+		if complete || streamErr != nil {
+			return errStopStream
+		}
+		return nil
+	}
+	var err error
+	if kind == responseJSON {
+		var data []byte
+		if data, err = io.ReadAll(resp.Body); err == nil && len(bytes.TrimSpace(data)) > 0 {
+			err = handle(bytes.TrimSpace(data))
+		}
+	} else {
+		err = readEventStream(ctx, resp.Body, handle)
+	}
+	if err != nil && err != errStopStream {
+		return nil, err
+	}
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	if moderation != nil {
+		if result == nil {
+			result = moderation
+		} else if result.ModerationResponse == nil {
+			result.ModerationResponse = moderation.ModerationResponse
+		}
+	}
+	if result == nil {
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		return nil, ErrEmptyResponse
+	}
+	return result, nil
 }
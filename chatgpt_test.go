@@ -1,10 +1,21 @@
 package chatgpt_go_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -14,20 +25,64 @@ var clearanceToken = os.Getenv("CLEARANCE_TOKEN")
 var userAgent = os.Getenv("USER_AGENT")
 
 func TestMain(m *testing.M) {
+	logrus.SetLevel(logrus.DebugLevel)
+	os.Exit(m.Run())
+}
+
+// requireLiveEnv skips tests that talk to chat.openai.com when the
+// credentials are not provided through the environment.
+func requireLiveEnv(t *testing.T) {
 	if sessionToken == "" {
-		panic("env SESSION_KEY not set")
+		t.Skip("env SESSION_KEY not set")
 	}
 	if clearanceToken == "" {
-		panic("env CLEARANCE_TOKEN not set")
+		t.Skip("env CLEARANCE_TOKEN not set")
 	}
 	if userAgent == "" {
-		panic("env USER_AGENT not set")
+		t.Skip("env USER_AGENT not set")
 	}
-	logrus.SetLevel(logrus.DebugLevel)
-	m.Run()
+}
+
+// newTestClient starts a mock server answering the session endpoint and
+// passing every other request to handler, and returns a client for it.
+func newTestClient(t *testing.T, options chatgpt_go.ChatGPTOptions, handler http.HandlerFunc) *chatgpt_go.ChatGPT {
+	sessionPath := options.SessionPath
+	if sessionPath == "" {
+		sessionPath = "/api/auth/session"
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == sessionPath {
+			_, _ = fmt.Fprintf(w, `{"accessToken":"test-token","expires":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	options.BaseURL = server.URL
+	options.SessionToken = "session"
+	options.ClearanceToken = "clearance"
+	if options.UserAgent == "" && len(options.UserAgents) == 0 {
+		options.UserAgent = "test-agent"
+	}
+	client, err := chatgpt_go.NewChatGPT(options)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return client
+}
+
+// writeStream writes frames as an event stream terminated by [DONE].
+func writeStream(w http.ResponseWriter, frames ...string) {
+	w.Header().Set("content-type", "text/event-stream")
+	for _, frame := range frames {
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", frame)
+	}
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
 }
 
 func TestChatGPT_SendMessage(t *testing.T) {
+	requireLiveEnv(t)
 	t.Logf("sessionToken: %s", sessionToken)
 	t.Logf("clearanceToken: %s", clearanceToken)
 	t.Logf("userAgent: %s", userAgent)
@@ -63,6 +118,7 @@ func TestChatGPT_SendMessage(t *testing.T) {
 }
 
 func TestChatGPT_RefreshAccessToken(t *testing.T) {
+	requireLiveEnv(t)
 	t.Logf("sessionToken: %s", sessionToken)
 	t.Logf("clearanceToken: %s", clearanceToken)
 	t.Logf("userAgent: %s", userAgent)
@@ -80,3 +136,832 @@ func TestChatGPT_RefreshAccessToken(t *testing.T) {
 		t.Logf("accessToken: %s", client.AccessToken)
 	}
 }
+
+func TestChatGPT_RefreshAccessTokenContext(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, http.NotFound)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, client.RefreshAccessTokenContext(ctx), context.Canceled)
+	assert.NoError(t, client.RefreshAccessTokenContext(context.Background()))
+	assert.Equal(t, "test-token", client.AccessToken)
+}
+
+func TestConversationBody_ExtraFields(t *testing.T) {
+	body := chatgpt_go.ConversationBody{Action: "next", Model: "gpt-4"}
+	assert.JSONEq(t, `{"action":"next","parent_message_id":"","model":"gpt-4"}`, string(body.JSON()))
+
+	body.ExtraFields = map[string]interface{}{"force_paragen": true, "model": "ignored"}
+	assert.JSONEq(t, `{"action":"next","parent_message_id":"","model":"gpt-4","force_paragen":true}`, string(body.JSON()))
+}
+
+func TestConversationBody_NullConversationId(t *testing.T) {
+	body := chatgpt_go.ConversationBody{Action: "next", Model: "gpt-4"}
+	assert.JSONEq(t, `{"action":"next","parent_message_id":"","model":"gpt-4"}`, string(body.JSON()))
+
+	body.NullConversationId = true
+	assert.JSONEq(t, `{"action":"next","parent_message_id":"","model":"gpt-4","conversation_id":null}`, string(body.JSON()))
+
+	body.ConversationId = "c1"
+	assert.JSONEq(t, `{"action":"next","parent_message_id":"","model":"gpt-4","conversation_id":"c1"}`, string(body.JSON()))
+}
+
+func TestChatGPT_BodySerializer(t *testing.T) {
+	var raw string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{
+		BodySerializer: func(body *chatgpt_go.ConversationBody) ([]byte, error) {
+			return []byte(fmt.Sprintf(`{"model":%q,"action":%q}`, body.Model, body.Action)), nil
+		},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		raw = string(bs)
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"model":"text-davinci-002-render","action":"next"}`, raw)
+}
+
+func TestChatGPT_EndpointPaths(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{
+		SessionPath:      "/v1/session",
+		ConversationPath: "/v1/conversation",
+	}, func(w http.ResponseWriter, r *http.Request) {
+		if !assert.Equal(t, "/v1/conversation", r.URL.Path) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "test-token", r.Header.Get("authorization"))
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	resp, err := client.NewConversation("", "").SendMessage("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hi", resp)
+	}
+
+	var referers []string
+	for _, refererPath := range []string{"", "/api"} {
+		client = newTestClient(t, chatgpt_go.ChatGPTOptions{RefererPath: refererPath}, func(w http.ResponseWriter, r *http.Request) {
+			referers = append(referers, strings.TrimPrefix(r.Header.Get("referer"), r.Header.Get("origin")))
+			writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+		})
+		_, err = client.NewConversation("", "").SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"/chat", "/api"}, referers)
+
+	_, err = chatgpt_go.NewChatGPT(chatgpt_go.ChatGPTOptions{
+		SessionToken:     "session",
+		ClearanceToken:   "clearance",
+		UserAgent:        "test-agent",
+		ConversationPath: "v1/conversation",
+	})
+	assert.Error(t, err)
+}
+
+type traceIdKey struct{}
+
+type requestIdKey struct{}
+
+func TestChatGPT_WithHeaderFromContext(t *testing.T) {
+	headers := http.Header{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	client.WithHeaderFromContext(traceIdKey{}, "x-trace-id").WithHeaderFromContext(requestIdKey{}, "x-request-id")
+
+	ctx := context.WithValue(context.Background(), traceIdKey{}, "trace-1")
+	ctx = context.WithValue(ctx, requestIdKey{}, 42)
+	_, err := client.NewConversation("", "").SendMessageContext(ctx, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "trace-1", headers.Get("x-trace-id"))
+	assert.Equal(t, "42", headers.Get("x-request-id"))
+
+	// keys missing from the context send no header
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.NotContains(t, headers, "X-Trace-Id")
+}
+
+func TestConversation_SendMessageTrace(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		for _, text := range []string{"h", "hi", "Hi"} {
+			_, _ = fmt.Fprintf(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[%q]}},\"conversation_id\":\"c1\"}\n\n", text)
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	resp, metrics, err := client.NewConversation("", "").SendMessageTrace("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hi", resp)
+		assert.Greater(t, metrics.FirstByte, time.Duration(0))
+		assert.GreaterOrEqual(t, metrics.Total, metrics.FirstByte)
+		assert.Equal(t, 3, metrics.Frames)
+		assert.GreaterOrEqual(t, metrics.MaxFrameGap, 20*time.Millisecond)
+		assert.True(t, metrics.NonMonotonic)
+	}
+}
+
+func TestConversation_SendMessageTrace_Refresh(t *testing.T) {
+	sessions := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	unsubscribe := chatgpt_go.SubscribeAuthEvents(func(e chatgpt_go.AuthEvent) {
+		if e.Client == client && e.Type == chatgpt_go.TokenRefreshed {
+			sessions++
+		}
+	})
+	defer unsubscribe()
+
+	// the session request opens the connection the send then reuses: its
+	// connect time isn't the send's
+	_, metrics, err := client.NewConversation("", "").SendMessageTrace("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, sessions)
+		assert.True(t, metrics.ConnReused)
+		assert.Zero(t, metrics.Connect)
+		assert.Greater(t, metrics.FirstByte, time.Duration(0))
+	}
+}
+
+func TestChatGPT_DialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"accessToken":"test-token","expires":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	dialed := ""
+	client, err := chatgpt_go.NewChatGPT(chatgpt_go.ChatGPTOptions{
+		SessionToken:   "session",
+		ClearanceToken: "clearance",
+		UserAgent:      "test-agent",
+		BaseURL:        "http://chat.example.com",
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = addr
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if assert.NoError(t, client.RefreshAccessToken()) {
+		assert.Equal(t, "chat.example.com:80", dialed)
+		assert.Equal(t, "test-token", client.AccessToken)
+	}
+}
+
+func TestChatGPT_BaseURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/proxy/api/auth/session" {
+			_, _ = fmt.Fprintf(w, `{"accessToken":"test-token","expires":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	}))
+	defer server.Close()
+
+	client, err := chatgpt_go.NewChatGPT(chatgpt_go.ChatGPTOptions{
+		SessionToken:   "session",
+		ClearanceToken: "clearance",
+		UserAgent:      "test-agent",
+		BaseURL:        server.URL + "/proxy/",
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/proxy/api/auth/session", "/proxy/backend-api/conversation"}, paths)
+
+	client, err = chatgpt_go.NewChatGPT(chatgpt_go.ChatGPTOptions{SessionToken: "session", ClearanceToken: "clearance", UserAgent: "test-agent"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "https://chat.openai.com", client.BaseURL)
+	}
+}
+
+func TestChatGPT_HTTPClient(t *testing.T) {
+	requests := 0
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{HTTPClient: &http.Client{Transport: transport}}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestChatGPT_UserAgents(t *testing.T) {
+	var agents []string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{UserAgents: []string{"ua-1", "ua-2"}}, func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("user-agent"))
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	assert.Equal(t, "ua-1", client.UserAgent)
+
+	first := client.NewConversation("", "")
+	second := client.NewConversation("", "")
+	for _, conversation := range []*chatgpt_go.Conversation{first, second, first, second} {
+		_, err := conversation.SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"ua-1", "ua-2", "ua-1", "ua-2"}, agents)
+
+	agents = nil
+	for i := 0; i < 2; i++ {
+		_, err := client.NewConversation("c1", "m1").SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, agents[0], agents[1])
+
+	// resuming a conversation keeps the agent it was started with
+	ids := 0
+	agents = nil
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{UserAgents: []string{"ua-1", "ua-2", "ua-3"}}, func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("user-agent"))
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		id, _ := body["conversation_id"].(string)
+		if id == "" {
+			ids++
+			id = fmt.Sprintf("new-%d", ids)
+		}
+		writeStream(w, fmt.Sprintf(`{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":%q}`, id))
+	})
+	var started []*chatgpt_go.Conversation
+	for i := 0; i < 3; i++ {
+		conversation := client.NewConversation("", "")
+		_, err := conversation.SendMessage("hello")
+		assert.NoError(t, err)
+		started = append(started, conversation)
+	}
+	for i, conversation := range started {
+		_, err := client.NewConversation(conversation.ConversationId, "m1").SendMessage("hello")
+		assert.NoError(t, err)
+		token, err := conversation.Marshal()
+		if assert.NoError(t, err) {
+			resumed, err := client.UnmarshalConversation(token)
+			if assert.NoError(t, err) {
+				_, err = resumed.SendMessage("hello")
+				assert.NoError(t, err)
+			}
+		}
+		assert.Equal(t, []string{agents[i], agents[i]}, agents[len(agents)-2:])
+	}
+}
+
+func TestChatGPT_SetUserAgent(t *testing.T) {
+	var agents []string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{UserAgent: " Mozilla/5.0 first\n"}, func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.Header.Get("user-agent"))
+		if r.URL.Path == "/other" {
+			_, _ = fmt.Fprintf(w, `{"accessToken":"test-token","expires":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	client.SessionPath = "/other"
+	client.ValidateUserAgent = true
+
+	conversation := client.NewConversation("", "")
+	assert.NoError(t, client.RefreshAccessToken())
+	_, err := conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Mozilla/5.0 first", "Mozilla/5.0 first"}, agents)
+
+	assert.ErrorIs(t, client.SetUserAgent("curl/8.0"), chatgpt_go.ErrInvalidUserAgent)
+	assert.ErrorIs(t, client.SetUserAgent("Mozilla/5.0\r\nx-injected: 1"), chatgpt_go.ErrInvalidUserAgent)
+	assert.NoError(t, client.SetUserAgent("Mozilla/5.0 second"))
+
+	agents = nil
+	client.AccessToken = ""
+	assert.NoError(t, client.RefreshAccessToken())
+	_, err = conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Mozilla/5.0 second", "Mozilla/5.0 second"}, agents)
+}
+
+func TestNewChatGPT_ValidateUserAgent(t *testing.T) {
+	options := chatgpt_go.ChatGPTOptions{SessionToken: "session", ClearanceToken: "clearance", UserAgent: "Go-http-client/1.1", ValidateUserAgent: true}
+	_, err := chatgpt_go.NewChatGPT(options)
+	assert.ErrorIs(t, err, chatgpt_go.ErrInvalidUserAgent)
+
+	options.ValidateUserAgent = false
+	_, err = chatgpt_go.NewChatGPT(options)
+	assert.NoError(t, err)
+
+	options.UserAgent = "a\x00b"
+	_, err = chatgpt_go.NewChatGPT(options)
+	assert.ErrorIs(t, err, chatgpt_go.ErrInvalidUserAgent)
+}
+
+type recordLogger struct {
+	msgs   []string
+	fields []map[string]interface{}
+}
+
+func (l *recordLogger) Debug(msg string, fields map[string]interface{}) {
+	l.msgs = append(l.msgs, msg)
+	l.fields = append(l.fields, fields)
+}
+
+func TestChatGPT_Logger(t *testing.T) {
+	logger := &recordLogger{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{Logger: logger}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Contains(t, logger.msgs, "send_request")
+	assert.Contains(t, logger.msgs, "send_response")
+
+	// Log keeps working through the logrus adapter, also when set later
+	for _, late := range []bool{false, true} {
+		out := &bytes.Buffer{}
+		log := logrus.New()
+		log.SetOutput(out)
+		log.SetLevel(logrus.DebugLevel)
+		options := chatgpt_go.ChatGPTOptions{}
+		if !late {
+			options.Log = logrus.NewEntry(log)
+		}
+		client = newTestClient(t, options, func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+		})
+		if late {
+			client.Log = logrus.NewEntry(log)
+		}
+		_, err = client.NewConversation("", "").SendMessage("hello")
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), "send_request")
+	}
+}
+
+func TestChatGPT_RequestInterceptor(t *testing.T) {
+	var paths []string
+	var sent map[string]interface{}
+	var interceptErr error
+	requests := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{RequestInterceptor: func(req *http.Request) error {
+		paths = append(paths, req.URL.Path)
+		if req.GetBody != nil {
+			body, _ := req.GetBody()
+			_ = json.NewDecoder(body).Decode(&sent)
+		}
+		req.Header.Set("x-intercepted", "1")
+		return interceptErr
+	}}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "1", r.Header.Get("x-intercepted"))
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+
+	assert.NoError(t, client.RefreshAccessToken())
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/api/auth/session", "/backend-api/conversation"}, paths)
+	assert.Equal(t, "next", sent["action"])
+
+	interceptErr = errors.New("dry run")
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, interceptErr)
+	assert.Equal(t, 1, requests)
+}
+
+func TestConversation_SendMessage_LoginPage(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, "<!DOCTYPE html><html><body>Log in to ChatGPT</body></html>")
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrLoginRequired)
+}
+
+func TestConversation_SendMessagePreview(t *testing.T) {
+	done := make(chan struct{})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		w.Header().Set("content-type", "text/event-stream")
+		text := ""
+		for i := 0; i < 100; i++ {
+			text += "word "
+			_, err := fmt.Fprintf(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[%q]}},\"conversation_id\":\"c1\"}\n\n", text)
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		t.Error("generation was not cancelled")
+	})
+	conversation := client.NewConversation("", "")
+	resp, err := conversation.SendMessagePreview("hello", 12)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "word word wo", resp)
+		assert.Equal(t, "c1", conversation.ConversationId)
+	}
+	<-done
+
+	_, err = conversation.SendMessagePreview("hello", -1)
+	assert.Error(t, err)
+}
+
+func TestConversation_SendMessage_Moderation(t *testing.T) {
+	blocked := false
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["I can't"]}},"conversation_id":"c1"}`,
+			fmt.Sprintf(`{"type":"moderation","moderation_response":{"flagged":true,"blocked":%t,"moderation_id":"modr-1"},"message_id":"m1","conversation_id":"c1"}`, blocked),
+		)
+	})
+	conversation := client.NewConversation("", "")
+	resp, err := conversation.SendMessage("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "I can't", resp)
+		assert.Equal(t, "m1", conversation.ParentMessageId)
+	}
+
+	blocked = true
+	_, err = conversation.SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrContentBlocked)
+	assert.Equal(t, "m1", conversation.ParentMessageId)
+}
+
+func TestConversation_SendMessage_NoContent(t *testing.T) {
+	for _, frame := range []string{
+		`{"type":"moderation","moderation_response":{"flagged":true,"blocked":false,"moderation_id":"modr-1"},"message_id":"m1"}`,
+		`{"message":{"id":"m1","content":{"parts":[]}},"conversation_id":"c1"}`,
+	} {
+		client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+			writeStream(w, frame)
+		})
+		_, err := client.NewConversation("", "").SendMessage("hello")
+		if assert.ErrorIs(t, err, chatgpt_go.ErrNoContent) {
+			assert.Contains(t, err.Error(), frame)
+		}
+	}
+}
+
+func TestConversation_SendMessage_RetryBackoff(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 3, RetryBackoff: 10 * time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	message, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", message)
+	assert.Equal(t, 3, calls)
+
+	// a retry that can't happen before the deadline isn't attempted
+	calls = 0
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 3, RetryBackoff: time.Hour}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err = client.NewConversation("", "").SendMessageContext(ctx, "hello")
+	var statusErr *chatgpt_go.StatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	}
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), time.Second)
+
+	calls = 0
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 3, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// a Retry-After too long to wait for fails the send right away
+	calls = 0
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 3}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("retry-after", "86400")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	start = time.Now()
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+		assert.Equal(t, 24*time.Hour, statusErr.RetryAfter)
+	}
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestConversation_SendMessage_MessageCap(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 2}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"detail":{"message":"You've reached the current usage cap for GPT-4.","code":"model_cap_exceeded","clears_in":3600}}`))
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrMessageCapReached)
+	assert.False(t, chatgpt_go.IsRetryable(err))
+	assert.Equal(t, 1, calls)
+
+	var capErr *chatgpt_go.MessageCapError
+	if assert.ErrorAs(t, err, &capErr) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), capErr.ResetAt, time.Minute)
+		assert.Equal(t, "You've reached the current usage cap for GPT-4.", capErr.Message)
+	}
+}
+
+func TestChatGPT_TimezoneOffset(t *testing.T) {
+	var bodies []map[string]interface{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	}
+	offset := -480
+	for _, options := range []chatgpt_go.ChatGPTOptions{{}, {TimezoneOffset: &offset}, {UseLocalTimezone: true}} {
+		_, err := newTestClient(t, options, handler).NewConversation("", "").SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	_, localOffset := time.Now().Zone()
+	if assert.Len(t, bodies, 3) {
+		assert.NotContains(t, bodies[0], "timezone_offset_min")
+		assert.Equal(t, float64(-480), bodies[1]["timezone_offset_min"])
+		assert.Equal(t, float64(-localOffset/60), bodies[2]["timezone_offset_min"])
+	}
+}
+
+func TestConversation_SendMessage_StrictModel(t *testing.T) {
+	served := ""
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{StrictModel: true}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, fmt.Sprintf(`{"message":{"id":"m1","content":{"parts":["hi"]},"metadata":{"model_slug":%q}},"conversation_id":"c1"}`, served))
+	})
+
+	// variants of the requested model are accepted
+	for _, served = range []string{"text-davinci-002-render", "text-davinci-002-render-sha"} {
+		_, err := client.NewConversation("", "").SendMessage("hello")
+		assert.NoError(t, err, served)
+	}
+
+	served = "text-davinci-002-render-sha"
+	_, err := client.NewConversation("", "").SetModel("gpt-4").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrModelDowngraded)
+
+	client.StrictModel = false
+	_, err = client.NewConversation("", "").SetModel("gpt-4").SendMessage("hello")
+	assert.NoError(t, err)
+}
+
+func TestConversation_Model(t *testing.T) {
+	var models []interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		models = append(models, body["model"])
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]},"metadata":{"model_slug":"gpt-3.5"}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	for i := 0; i < 2; i++ {
+		_, err := conversation.SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	_, err := conversation.SetModel("gpt-4").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"text-davinci-002-render", "gpt-3.5", "gpt-4"}, models)
+}
+
+func TestConversation_IsNew(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	assert.True(t, conversation.IsNew())
+	_, err := conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	assert.False(t, conversation.IsNew())
+	assert.False(t, client.NewConversation("c1", "m1").IsNew())
+
+	conversation.Reset()
+	assert.True(t, conversation.IsNew())
+}
+
+func TestChatGPT_ConversationMode(t *testing.T) {
+	var bodies []map[string]interface{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	}
+	for _, options := range []chatgpt_go.ChatGPTOptions{{}, {ConversationMode: "primary_assistant"}} {
+		_, err := newTestClient(t, options, handler).NewConversation("", "").SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, bodies, 2) {
+		assert.NotContains(t, bodies[0], "conversation_mode")
+		assert.Equal(t, map[string]interface{}{"kind": "primary_assistant"}, bodies[1]["conversation_mode"])
+	}
+}
+
+func TestConversation_SendMessage_RestartLocked(t *testing.T) {
+	var bodies []map[string]interface{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if body["conversation_id"] == "locked" {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"detail":"Conversation is locked"}`))
+			return
+		}
+		writeStream(w, `{"message":{"id":"m2","content":{"parts":["hi"]}},"conversation_id":"fresh"}`)
+	}
+
+	conversation := newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler).NewConversation("locked", "m1")
+	_, err := conversation.SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrConversationLocked)
+	assert.Equal(t, "locked", conversation.ConversationId)
+
+	bodies = nil
+	conversation = newTestClient(t, chatgpt_go.ChatGPTOptions{RestartLockedConversations: true}, handler).NewConversation("locked", "m1")
+	resp, err := conversation.SendMessage("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hi", resp)
+		assert.Equal(t, "fresh", conversation.ConversationId)
+	}
+	if assert.Len(t, bodies, 2) {
+		assert.NotContains(t, bodies[1], "conversation_id")
+		assert.NotEqual(t, "m1", bodies[1]["parent_message_id"])
+		assert.Equal(t, bodies[0]["messages"], bodies[1]["messages"])
+	}
+}
+
+func TestConversation_SendMessage_BackendGlitch(t *testing.T) {
+	reply := "Hmm...something seems to have gone wrong. Maybe try me again in a little bit."
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, fmt.Sprintf(`{"message":{"id":"m1","content":{"parts":[%q]}},"conversation_id":"c1"}`, reply))
+	}
+	_, err := newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler).NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrBackendGlitch)
+	assert.True(t, chatgpt_go.IsRetryable(err))
+
+	reply = "Hmm... quelque chose s'est mal passé."
+	_, err = newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler).NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	_, err = newTestClient(t, chatgpt_go.ChatGPTOptions{GlitchMessages: []string{"hmm... quelque chose s'est mal passé"}}, handler).NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrBackendGlitch)
+
+	reply = "  something went wrong!\n"
+	_, err = newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler).NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrBackendGlitch)
+
+	// a reply merely starting like a glitch is a real answer
+	reply = "Something went wrong in your code because the slice is nil."
+	_, err = newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler).NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+
+	reply = "Something went wrong"
+	_, err = newTestClient(t, chatgpt_go.ChatGPTOptions{GlitchMessages: []string{}}, handler).NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+}
+
+func TestChatGPT_RolePrefix(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{RolePrefix: chatgpt_go.DefaultRolePrefix}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["Assis"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Assistant: He"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Assistant: Hello\nAssistant: again"]}},"conversation_id":"c1"}`,
+		)
+	})
+	message, err := client.NewConversation("", "").SendMessage("hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello\nAssistant: again", message)
+
+	var deltas []string
+	message, err = client.NewConversation("", "").SendMessageStream("hi", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello\nAssistant: again", message)
+	assert.Equal(t, []string{"Hello\nAssistant: again"}, deltas)
+
+	client.RolePrefix = nil
+	message, err = client.NewConversation("", "").SendMessage("hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "Assistant: Hello\nAssistant: again", message)
+}
+
+func TestChatGPT_KeepAlive(t *testing.T) {
+	var remotes []string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		remotes = append(remotes, r.RemoteAddr)
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	for i := 0; i < 3; i++ {
+		_, err := client.NewConversation("", "").SendMessage("hello")
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, remotes, 3) {
+		assert.Equal(t, remotes[0], remotes[1])
+		assert.Equal(t, remotes[0], remotes[2])
+	}
+}
+
+func TestChatGPT_Timeout(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{Timeout: &timeout}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	start := time.Now()
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, chatgpt_go.IsRetryable(err))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// an attempt that timed out before the response is retried
+	var calls int32
+	release := make(chan struct{})
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{Timeout: &timeout, MaxRetries: 1, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	resp, err := client.NewConversation("", "").SendMessage("hello")
+	close(release)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", resp)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestConversation_SendMessage_CloudflareChallenge(t *testing.T) {
+	page := `<!DOCTYPE html><html><head><title>Just a moment...</title></head><body><div id="challenge-platform"></div></body></html>`
+	status, calls := http.StatusForbidden, 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("content-type", "text/html; charset=UTF-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(page))
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrCloudflareChallenge)
+	assert.Contains(t, err.Error(), "Just a moment...")
+
+	// a 503 challenge isn't retried
+	status, calls = http.StatusServiceUnavailable, 0
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrCloudflareChallenge)
+	assert.False(t, chatgpt_go.IsRetryable(err))
+	assert.Equal(t, 1, calls)
+	status = http.StatusForbidden
+
+	// on the session endpoint too, rather than ErrSessionExpired
+	client.SessionPath = "/challenged-session"
+	client.AccessToken = ""
+	err = client.RefreshAccessToken()
+	assert.ErrorIs(t, err, chatgpt_go.ErrCloudflareChallenge)
+	assert.NotErrorIs(t, err, chatgpt_go.ErrSessionExpired)
+
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"detail":"forbidden"}`))
+	})
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.NotErrorIs(t, err, chatgpt_go.ErrCloudflareChallenge)
+}
@@ -0,0 +1,191 @@
+package chatgpt_go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	endpointUpdateConversation = "update_conversation"
+	endpointListConversations  = "list_conversations"
+	endpointGenerateTitle      = "gen_title"
+	endpointMessageFeedback    = "message_feedback"
+	defaultListLimit           = 20
+)
+
+// ConversationSummary is an entry of the account's conversation list.
+type ConversationSummary struct {
+	Id         string
+	Title      string
+	CreateTime time.Time
+	UpdateTime time.Time
+}
+
+// ListConversations returns a page of the account's conversations, most
+// recently updated first. A limit of 0 asks for 20 conversations, the page
+// size of the web UI. Their ids can be passed to NewConversation to carry
+// them on.
+func (c *ChatGPT) ListConversations(offset, limit int) ([]ConversationSummary, error) {
+	if offset < 0 || limit < 0 {
+		return nil, fmt.Errorf("invalid page offset=%d limit=%d", offset, limit)
+	}
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+	var resp struct {
+		Items []struct {
+			Id         string      `json:"id"`
+			Title      string      `json:"title"`
+			CreateTime interface{} `json:"create_time"`
+			UpdateTime interface{} `json:"update_time"`
+		} `json:"items"`
+	}
+	path := fmt.Sprintf("%s?offset=%d&limit=%d&order=updated", c.ConversationsPath, offset, limit)
+	if err := c.doJSON(context.Background(), endpointListConversations, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	summaries := make([]ConversationSummary, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		summaries = append(summaries, ConversationSummary{
+			Id:         item.Id,
+			Title:      item.Title,
+			CreateTime: unixTime(item.CreateTime),
+			UpdateTime: unixTime(item.UpdateTime),
+		})
+	}
+	return summaries, nil
+}
+
+// DeleteConversation hides the conversation from the account's history.
+func (c *ChatGPT) DeleteConversation(conversationId string) error {
+	return c.updateConversation(context.Background(), conversationId, map[string]interface{}{"is_visible": false})
+}
+
+// GenerateTitle asks the backend to title the conversation after its first
+// exchange, as the web UI does, and returns the title. messageId is the
+// reply, i.e. the conversation's ParentMessageId after SendMessage. The
+// title is generated with the default model; Conversation.GenerateTitle
+// uses the conversation's.
+func (c *ChatGPT) GenerateTitle(conversationId string, messageId string) (string, error) {
+	return c.generateTitle(conversationId, messageId, defaultModel)
+}
+
+// GenerateTitle is ChatGPT.GenerateTitle for the conversation's last reply,
+// with its model: the Model set, otherwise the one that served the reply.
+func (c *Conversation) GenerateTitle() (string, error) {
+	return c.ChatGPT.generateTitle(c.ConversationId, c.ParentMessageId, c.model())
+}
+
+func (c *ChatGPT) generateTitle(conversationId string, messageId string, model string) (string, error) {
+	var resp struct {
+		Title string `json:"title"`
+	}
+	in := map[string]interface{}{"message_id": messageId, "model": model}
+	if err := c.doJSON(context.Background(), endpointGenerateTitle, http.MethodPost, c.ConversationPath+"/gen_title/"+conversationId, in, &resp); err != nil {
+		return "", err
+	}
+	if resp.Title == "" {
+		return "", fmt.Errorf("%w: no title generated", ErrNoContent)
+	}
+	return resp.Title, nil
+}
+
+// Ratings accepted by SubmitFeedback.
+const (
+	RatingThumbsUp   = "thumbsUp"
+	RatingThumbsDown = "thumbsDown"
+)
+
+// SubmitFeedback rates a reply of the conversation as the thumbs of the web
+// UI do. messageId is the reply's id, e.g. the Message.Id of the result of
+// SendMessageFull. ErrInvalidRating is returned for a rating other than
+// RatingThumbsUp and RatingThumbsDown.
+func (c *ChatGPT) SubmitFeedback(conversationId string, messageId string, rating string) error {
+	if rating != RatingThumbsUp && rating != RatingThumbsDown {
+		return fmt.Errorf("%w: %q", ErrInvalidRating, rating)
+	}
+	in := map[string]interface{}{"conversation_id": conversationId, "message_id": messageId, "rating": rating}
+	return c.doJSON(context.Background(), endpointMessageFeedback, http.MethodPost, c.ConversationPath+"/message_feedback", in, nil)
+}
+
+// ArchiveConversation moves the conversation to the account's archived
+// conversations.
+func (c *ChatGPT) ArchiveConversation(conversationId string) error {
+	return c.updateConversation(context.Background(), conversationId, map[string]interface{}{"is_archived": true})
+}
+
+func (c *ChatGPT) updateConversation(ctx context.Context, conversationId string, fields map[string]interface{}) error {
+	return c.doJSON(ctx, endpointUpdateConversation, http.MethodPatch, c.ConversationPath+"/"+conversationId, fields, nil)
+}
+
+// doJSON sends a JSON request to one of the auxiliary backend-api endpoints
+// and decodes the JSON response into out when it is not nil. Like the
+// conversation endpoint it refreshes the access token first, retries
+// transient failures and returns a *StatusError for unexpected statuses.
+func (c *ChatGPT) doJSON(ctx context.Context, endpoint string, method string, path string, in interface{}, out interface{}) error {
+	if err := c.RefreshAccessTokenContext(ctx); err != nil {
+		return fmt.Errorf("refresh access token: %w", err)
+	}
+	var body io.Reader
+	if in != nil {
+		bs, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(bs)
+	}
+	req, err := c.newRequest(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	c.setAuthHeaders(req)
+	if in != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	resp, err := c.do(endpoint, req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	c.debug(method+" "+req.URL.String(), map[string]interface{}{"status_code": resp.StatusCode, "body": string(b)})
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, b)
+	}
+	if out != nil {
+		if err := json.Unmarshal(b, out); err != nil {
+			return fmt.Errorf("JSON %s format: %w", string(b), err)
+		}
+	}
+	return nil
+}
+
+// SmokeTest sends SmokeTestPrompt in a new conversation and returns how long
+// the round trip took. The conversation is deleted afterwards unless
+// SmokeTestKeep is set.
+func (c *ChatGPT) SmokeTest() (time.Duration, error) {
+	prompt := c.SmokeTestPrompt
+	if prompt == "" {
+		prompt = "hi"
+	}
+	conversation := c.NewConversation("", "")
+	start := time.Now()
+	if _, err := conversation.SendMessage(prompt); err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+	if !c.SmokeTestKeep && conversation.ConversationId != "" {
+		if err := c.DeleteConversation(conversation.ConversationId); err != nil {
+			return latency, fmt.Errorf("delete conversation: %w", err)
+		}
+	}
+	return latency, nil
+}
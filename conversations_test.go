@@ -0,0 +1,218 @@
+package chatgpt_go_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_SmokeTest(t *testing.T) {
+	deleted := false
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/backend-api/conversation":
+			writeStream(w, `{"message":{"id":"m1","content":{"parts":["hello"]}},"conversation_id":"c1"}`)
+		case r.Method == http.MethodPatch && r.URL.Path == "/backend-api/conversation/c1":
+			body, _ := io.ReadAll(r.Body)
+			assert.JSONEq(t, `{"is_visible":false}`, string(body))
+			deleted = true
+			_, _ = w.Write([]byte(`{"success":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	latency, err := client.SmokeTest()
+	if assert.NoError(t, err) {
+		assert.Greater(t, latency, time.Duration(0))
+		assert.True(t, deleted)
+	}
+}
+
+func TestChatGPT_DeleteConversation_Retry(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	assert.NoError(t, client.DeleteConversation("c1"))
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	})
+	err := client.DeleteConversation("c1")
+	var statusErr *chatgpt_go.StatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestChatGPT_GenerateTitle_Retry(t *testing.T) {
+	calls := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/backend-api/conversation/gen_title/c1", r.URL.Path)
+		body := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "m1", body["message_id"])
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"title":"Friendly Greeting"}`))
+	})
+	title, err := client.GenerateTitle("c1", "m1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Friendly Greeting", title)
+	assert.Equal(t, 2, calls)
+}
+
+func TestChatGPT_RetryableStatuses(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(522)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true}`))
+	}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 1}, handler)
+	assert.Error(t, client.DeleteConversation("c1"))
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 1, RetryBackoff: time.Millisecond, RetryableStatuses: []int{520, 522}}, handler)
+	assert.NoError(t, client.DeleteConversation("c1"))
+	assert.Equal(t, 2, calls)
+}
+
+func TestChatGPT_ListConversations(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/backend-api/conversations", r.URL.Path)
+		assert.Equal(t, "test-token", r.Header.Get("authorization"))
+		assert.Equal(t, "40", r.URL.Query().Get("offset"))
+		assert.Equal(t, "20", r.URL.Query().Get("limit"))
+		_, _ = w.Write([]byte(`{"items":[
+			{"id":"c1","title":"First","create_time":"2023-04-01T10:00:00.123456+00:00","update_time":"2023-04-02T10:00:00.000000+00:00"},
+			{"id":"c2","title":"Second","create_time":null}],"total":42,"limit":20,"offset":40}`))
+	})
+	summaries, err := client.ListConversations(40, 0)
+	if assert.NoError(t, err) && assert.Len(t, summaries, 2) {
+		assert.Equal(t, "c1", summaries[0].Id)
+		assert.Equal(t, "First", summaries[0].Title)
+		assert.Equal(t, time.Date(2023, 4, 1, 10, 0, 0, 123456000, time.UTC), summaries[0].CreateTime.UTC())
+		assert.Equal(t, time.Date(2023, 4, 2, 10, 0, 0, 0, time.UTC), summaries[0].UpdateTime.UTC())
+		assert.True(t, summaries[1].CreateTime.IsZero())
+	}
+
+	_, err = client.ListConversations(0, -1)
+	assert.Error(t, err)
+
+	// the list path doesn't derive from a customised ConversationPath
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{ConversationPath: "/v1/conversation/", ConversationsPath: "/v1/chats"}, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chats", r.URL.Path)
+		_, _ = w.Write([]byte(`{"items":[]}`))
+	})
+	summaries, err = client.ListConversations(0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, summaries)
+}
+
+func TestChatGPT_ArchiveConversation(t *testing.T) {
+	var (
+		method string
+		body   map[string]interface{}
+	)
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		assert.Equal(t, "/backend-api/conversation/c1", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("content-type"))
+		body = nil
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"success":true}`))
+	})
+	assert.NoError(t, client.ArchiveConversation("c1"))
+	assert.Equal(t, http.MethodPatch, method)
+	assert.Equal(t, map[string]interface{}{"is_archived": true}, body)
+
+	assert.NoError(t, client.DeleteConversation("c1"))
+	assert.Equal(t, map[string]interface{}{"is_visible": false}, body)
+}
+
+func TestChatGPT_GenerateTitle(t *testing.T) {
+	var models []interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backend-api/conversation":
+			writeStream(w, `{"message":{"id":"m1","content":{"parts":["Hello!"]},"metadata":{"model_slug":"gpt-4"}},"conversation_id":"c1"}`)
+		case "/backend-api/conversation/gen_title/c1":
+			assert.Equal(t, http.MethodPost, r.Method)
+			body := map[string]interface{}{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "m1", body["message_id"])
+			models = append(models, body["model"])
+			_, _ = w.Write([]byte(`{"title":"Friendly Greeting"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	conversation := client.NewConversation("", "")
+	_, err := conversation.SendMessage("hi")
+	assert.NoError(t, err)
+	title, err := client.GenerateTitle(conversation.ConversationId, conversation.ParentMessageId)
+	assert.NoError(t, err)
+	assert.Equal(t, "Friendly Greeting", title)
+
+	// the conversation's own model: the served one, then the one set
+	title, err = conversation.GenerateTitle()
+	assert.NoError(t, err)
+	assert.Equal(t, "Friendly Greeting", title)
+	_, err = conversation.SetModel("gpt-4-mobile").GenerateTitle()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"text-davinci-002-render", "gpt-4", "gpt-4-mobile"}, models)
+
+	_, err = client.GenerateTitle("unknown", "m1")
+	var statusErr *chatgpt_go.StatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	}
+}
+
+func TestChatGPT_SubmitFeedback(t *testing.T) {
+	var body map[string]interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backend-api/conversation":
+			writeStream(w, `{"message":{"id":"m1","content":{"parts":["Hello!"]}},"conversation_id":"c1"}`)
+		case "/backend-api/conversation/message_feedback":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "test-token", r.Header.Get("authorization"))
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			_, _ = w.Write([]byte(`{"message_id":"m1","conversation_id":"c1","rating":"thumbsUp"}`))
+		}
+	})
+	result, err := client.NewConversation("", "").SendMessageFull("hi")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NoError(t, client.SubmitFeedback(result.ConversationId, result.Message.Id, chatgpt_go.RatingThumbsUp))
+	assert.Equal(t, map[string]interface{}{"conversation_id": "c1", "message_id": "m1", "rating": "thumbsUp"}, body)
+
+	body = nil
+	err = client.SubmitFeedback("c1", "m1", "meh")
+	assert.ErrorIs(t, err, chatgpt_go.ErrInvalidRating)
+	assert.Nil(t, body)
+}
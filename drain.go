@@ -0,0 +1,106 @@
+package chatgpt_go
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxDrain caps how much of a cancelled response is read to reuse its
+// connection.
+const maxDrain = 4 << 20
+
+// detachedContext carries the values of its parent but not its
+// cancellation, so that a request can outlive its caller while its
+// response is drained.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// doDrained is do for DrainTimeout: until the response arrives cancelling
+// ctx cancels the request as usual. Afterwards reads of the body fail as
+// soon as ctx is done, but the connection is kept open while the rest of
+// the body is drained in the background.
+func (c *ChatGPT) doDrained(ctx context.Context, endpoint string, req *http.Request) (*http.Response, error) {
+	reqCtx, abort := context.WithCancel(detachedContext{ctx})
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			abort()
+		case <-stop:
+		}
+	}()
+	resp, err := c.do(endpoint, req.WithContext(reqCtx))
+	close(stop)
+	if err != nil {
+		abort()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	resp.Body = c.newDrainingBody(ctx, resp.Body, abort)
+	return resp, nil
+}
+
+// drainingBody is a response body read through a pipe, so that reads can
+// be abandoned when the caller's context is done without closing the
+// connection.
+type drainingBody struct {
+	*io.PipeReader
+	closed    chan struct{}
+	closeOnce sync.Once
+	cancelled int32
+}
+
+func (c *ChatGPT) newDrainingBody(ctx context.Context, body io.ReadCloser, abort context.CancelFunc) *drainingBody {
+	pr, pw := io.Pipe()
+	b := &drainingBody{PipeReader: pr, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-b.closed:
+				// closed by the reader first
+			default:
+				atomic.StoreInt32(&b.cancelled, 1)
+				_ = pw.CloseWithError(ctx.Err())
+			}
+		case <-b.closed:
+		}
+	}()
+	go func() {
+		defer abort()
+		defer func() { _ = body.Close() }()
+		_, err := io.Copy(pw, body)
+		_ = pw.CloseWithError(err)
+		if err == nil || atomic.LoadInt32(&b.cancelled) == 0 {
+			// complete, failed, or closed early on purpose, e.g. to stop
+			// the generation: nothing to drain
+			return
+		}
+		start := time.Now()
+		timer := time.AfterFunc(c.DrainTimeout, abort)
+		defer timer.Stop()
+		n, err := io.Copy(io.Discard, io.LimitReader(body, maxDrain))
+		c.debug("drain cancelled response", map[string]interface{}{"error": err, "bytes": n, "duration": time.Since(start)})
+	}()
+	return b
+}
+
+func (b *drainingBody) Close() error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	return b.PipeReader.Close()
+}
@@ -0,0 +1,65 @@
+package chatgpt_go_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_DrainTimeout(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		remotes []string
+	)
+	finished := make(chan struct{}, 2)
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{DrainTimeout: 5 * time.Second}, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes = append(remotes, r.RemoteAddr)
+		mu.Unlock()
+		w.Header().Set("content-type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hel\"]}},\"conversation_id\":\"c1\"}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			t.Error("connection was closed")
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+		_, _ = fmt.Fprint(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hello\"]}},\"conversation_id\":\"c1\"}\n\ndata: [DONE]\n\n")
+		finished <- struct{}{}
+	})
+	assert.NoError(t, client.RefreshAccessToken())
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	conversation := client.NewConversation("", "m0")
+	start := time.Now()
+	_, err := conversation.SendMessageContext(ctx, "hi")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("response was not drained")
+	}
+	// no goroutine is left behind and the drained connection is reused
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > goroutines && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines)
+	message, err := conversation.SendMessage("hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", message)
+	if assert.Len(t, remotes, 2) {
+		assert.Equal(t, remotes[0], remotes[1])
+	}
+}
@@ -0,0 +1,38 @@
+package chatgpt_go
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// NewChatGPTFromEnv creates a client like NewChatGPT, filling the options
+// left empty from the environment:
+//
+//	CHATGPT_SESSION_TOKEN, CHATGPT_CLEARANCE_TOKEN, CHATGPT_USER_AGENT
+//	CHATGPT_ACCESS_TOKEN, CHATGPT_ACCESS_TOKEN_EXPIRES (RFC3339)
+//
+// A seeded access token is used until it expires, so a warm token survives
+// restarts; without one the token is fetched with the session token.
+func NewChatGPTFromEnv(options ChatGPTOptions) (*ChatGPT, error) {
+	if options.SessionToken == "" {
+		options.SessionToken = os.Getenv("CHATGPT_SESSION_TOKEN")
+	}
+	if options.ClearanceToken == "" {
+		options.ClearanceToken = os.Getenv("CHATGPT_CLEARANCE_TOKEN")
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = os.Getenv("CHATGPT_USER_AGENT")
+	}
+	if options.AccessToken == "" {
+		if token := os.Getenv("CHATGPT_ACCESS_TOKEN"); token != "" {
+			expires, err := time.Parse(time.RFC3339, os.Getenv("CHATGPT_ACCESS_TOKEN_EXPIRES"))
+			if err != nil {
+				return nil, fmt.Errorf("CHATGPT_ACCESS_TOKEN_EXPIRES: %w", err)
+			}
+			options.AccessToken = token
+			options.AccessTokenExpires = expires
+		}
+	}
+	return NewChatGPT(options)
+}
@@ -0,0 +1,30 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"testing"
+	"time"
+)
+
+func TestNewChatGPTFromEnv(t *testing.T) {
+	t.Setenv("CHATGPT_SESSION_TOKEN", "session")
+	t.Setenv("CHATGPT_CLEARANCE_TOKEN", "clearance")
+	t.Setenv("CHATGPT_USER_AGENT", "test-agent")
+	t.Setenv("CHATGPT_ACCESS_TOKEN", "warm-token")
+	t.Setenv("CHATGPT_ACCESS_TOKEN_EXPIRES", "2099-01-02T15:04:05Z")
+
+	client, err := chatgpt_go.NewChatGPTFromEnv(chatgpt_go.ChatGPTOptions{BaseURL: "http://127.0.0.1:0"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "session", client.SessionToken)
+	assert.Equal(t, "warm-token", client.AccessToken)
+	assert.Equal(t, time.Date(2099, 1, 2, 15, 4, 5, 0, time.UTC), client.AccessTokenExpires)
+	// the seeded token is still valid, so no session request is made
+	assert.NoError(t, client.RefreshAccessToken())
+
+	t.Setenv("CHATGPT_ACCESS_TOKEN_EXPIRES", "tomorrow")
+	_, err = chatgpt_go.NewChatGPTFromEnv(chatgpt_go.ChatGPTOptions{})
+	assert.Error(t, err)
+}
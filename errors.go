@@ -0,0 +1,261 @@
+package chatgpt_go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrModelDowngraded is returned when StrictModel is set and the backend
+	// served the response with a different model than the one requested.
+	ErrModelDowngraded = errors.New("model downgraded")
+	// ErrEmptyResponse is returned when the conversation response has no
+	// body or the event stream ends before any event was received.
+	ErrEmptyResponse = errors.New("empty response")
+	// ErrInvalidConversationToken is returned by UnmarshalConversation for
+	// malformed or tampered tokens.
+	ErrInvalidConversationToken = errors.New("invalid conversation token")
+	// ErrRateLimited is returned when the backend answers 429.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrModelOverloaded is returned when the backend reports the requested
+	// model is overloaded with other requests.
+	ErrModelOverloaded = errors.New("model overloaded")
+	// ErrLoginRequired is returned when the backend answers with the HTML
+	// login page instead of an event stream: the session is no longer valid.
+	ErrLoginRequired = errors.New("login required")
+	// ErrContentBlocked is returned by GetMessage when moderation blocked
+	// the response.
+	ErrContentBlocked = errors.New("content blocked")
+	// ErrActionNotSupported is returned when the conversation's model is
+	// known not to support the requested action.
+	ErrActionNotSupported = errors.New("action not supported")
+	// ErrConversationLocked is returned when the backend refuses new
+	// messages because the conversation is locked or in an errored state.
+	ErrConversationLocked = errors.New("conversation locked")
+	// ErrCircuitOpen is returned without sending the request while the
+	// circuit breaker is open after repeated failures.
+	ErrCircuitOpen = errors.New("circuit open")
+	// ErrNoContent is returned when the final event of a response carries
+	// no message content, e.g. a moderation-only event. The error message
+	// includes the raw event.
+	ErrNoContent = errors.New("no content")
+	// ErrMessageCapReached is returned when the account used up its message
+	// cap for the model, e.g. GPT-4's. Retrying fails until the cap resets;
+	// the error is wrapped in a *MessageCapError telling when.
+	ErrMessageCapReached = errors.New("message cap reached")
+	// ErrSessionExpired is returned when the session endpoint rejects the
+	// session token: a new one must be obtained by logging in again.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrInvalidUserAgent is returned by NewChatGPT and SetUserAgent for a
+	// user agent that can't be used, see ValidateUserAgent.
+	ErrInvalidUserAgent = errors.New("invalid user agent")
+	// ErrInvalidRating is returned by SubmitFeedback for an unknown rating.
+	ErrInvalidRating = errors.New("invalid rating")
+	// ErrNoConversations is returned by ResumeLatest when the account has
+	// no conversation to resume.
+	ErrNoConversations = errors.New("no conversations")
+	// ErrBackendGlitch is returned when the backend answered with a generic
+	// apology, one of the GlitchMessages, instead of a reply. Sending the
+	// message again usually works.
+	ErrBackendGlitch = errors.New("backend glitch")
+	// ErrInvalidParent is returned when the backend rejects the parent
+	// message of a send as not part of the conversation, e.g. after it was
+	// edited elsewhere. See CorrectParentMessage.
+	ErrInvalidParent = errors.New("invalid parent message")
+	// ErrInvalidJSON is returned by SendJSON when the reply holds no valid
+	// JSON.
+	ErrInvalidJSON = errors.New("invalid json")
+	// ErrStreamError is returned when the response stream carries an error
+	// event, e.g. {"error":"..."}, instead of the reply.
+	ErrStreamError = errors.New("stream error")
+	// ErrCloudflareChallenge is returned when Cloudflare answers with its
+	// HTML challenge page instead of the backend, typically because the
+	// ClearanceToken expired: a new one must be obtained by solving the
+	// challenge again, see SetClearanceToken.
+	ErrCloudflareChallenge = errors.New("cloudflare challenge")
+)
+
+// MessageCapError is returned, wrapped in a *StatusError, when the message
+// cap of the model was reached. Sends fail until ResetAt, which is zero
+// when the backend didn't tell.
+type MessageCapError struct {
+	ResetAt time.Time
+	Message string
+}
+
+// newMessageCapError parses a model_cap_exceeded response body such as
+// {"detail":{"code":"model_cap_exceeded","message":"...","clears_in":3600}}.
+func newMessageCapError(body []byte) *MessageCapError {
+	var resp struct {
+		Detail struct {
+			Message  string  `json:"message"`
+			ClearsIn float64 `json:"clears_in"`
+		} `json:"detail"`
+	}
+	e := &MessageCapError{}
+	if json.Unmarshal(body, &resp) == nil {
+		e.Message = resp.Detail.Message
+		if resp.Detail.ClearsIn > 0 {
+			e.ResetAt = time.Now().Add(time.Duration(resp.Detail.ClearsIn * float64(time.Second)))
+		}
+	}
+	return e
+}
+
+func (e *MessageCapError) Error() string {
+	if e.ResetAt.IsZero() {
+		return ErrMessageCapReached.Error()
+	}
+	return fmt.Sprintf("%s, resets at %s", ErrMessageCapReached, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *MessageCapError) Unwrap() error {
+	return ErrMessageCapReached
+}
+
+// isMessageCap reports whether a response body is a message cap error.
+func isMessageCap(body []byte) bool {
+	return bytes.Contains(body, []byte("model_cap_exceeded"))
+}
+
+// isInvalidParent reports whether a response body rejects the parent
+// message, e.g. {"detail":"Parent message not found"}.
+func isInvalidParent(body []byte) bool {
+	body = bytes.ToLower(body)
+	return bytes.Contains(body, []byte("parent message")) || bytes.Contains(body, []byte("parent_message"))
+}
+
+// StatusError is returned when the backend answers with an unexpected
+// status code. It wraps one of the sentinel errors, e.g. ErrRateLimited,
+// when the response is recognized as such.
+type StatusError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the wait the backend asked for in the Retry-After
+	// header, zero when it didn't send one.
+	RetryAfter time.Duration
+
+	err error
+}
+
+// responseError returns the *StatusError for resp, whose body was read
+// into body.
+func responseError(resp *http.Response, body []byte) *StatusError {
+	e := newStatusError(resp.StatusCode, body)
+	e.RetryAfter = parseRetryAfter(resp.Header.Get("retry-after"), time.Now())
+	if isCloudflareChallenge(resp) {
+		e.err = ErrCloudflareChallenge
+	}
+	return e
+}
+
+// isCloudflareChallenge reports whether resp is a Cloudflare challenge: an
+// HTML page where the backend answers JSON, with the status Cloudflare
+// uses for challenges.
+func isCloudflareChallenge(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("content-type"))
+	return mediaType == "text/html"
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or
+// as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// rateLimitReset returns when the rate limit or message cap err reports
+// is lifted, when the backend told.
+func rateLimitReset(err error) (time.Time, bool) {
+	var capErr *MessageCapError
+	if errors.As(err, &capErr) {
+		return capErr.ResetAt, !capErr.ResetAt.IsZero()
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && errors.Is(err, ErrRateLimited) && statusErr.RetryAfter > 0 {
+		return time.Now().Add(statusErr.RetryAfter), true
+	}
+	return time.Time{}, false
+}
+
+func newStatusError(statusCode int, body []byte) *StatusError {
+	e := &StatusError{StatusCode: statusCode, Body: string(body)}
+	switch {
+	case isMessageCap(body):
+		e.err = newMessageCapError(body)
+	case bytes.Contains(body, []byte("overloaded")):
+		e.err = ErrModelOverloaded
+	case bytes.Contains(bytes.ToLower(body), []byte("conversation is locked")), bytes.Contains(body, []byte("conversation_locked")):
+		e.err = ErrConversationLocked
+	case statusCode >= 400 && statusCode < 500 && isInvalidParent(body):
+		e.err = ErrInvalidParent
+	case statusCode == 429:
+		e.err = ErrRateLimited
+	}
+	return e
+}
+
+// maxErrorBody caps how much of the body the message of a StatusError
+// includes, e.g. of an HTML error page. The Body field holds all of it.
+const maxErrorBody = 512
+
+func (e *StatusError) Error() string {
+	body := e.Body
+	if len(body) > maxErrorBody {
+		cut := maxErrorBody
+		for cut > 0 && !utf8.RuneStart(body[cut]) {
+			cut--
+		}
+		body = body[:cut] + "..."
+	}
+	return fmt.Sprintf("response status code=%d, body=%s", e.StatusCode, body)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying:
+// rate limits, overloaded models, backend glitches, 5xx responses, network
+// errors and streams cut short. Everything else, including 401/403,
+// invalid models, message caps, Cloudflare challenges and cancelled or
+// expired contexts, is permanent. This is the policy used by MaxRetries.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrMessageCapReached) || errors.Is(err, ErrCloudflareChallenge) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		// it is a net.Error too, but the caller's deadline won't come back
+		return false
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrModelOverloaded) || errors.Is(err, ErrBackendGlitch) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrEmptyResponse)
+}
@@ -0,0 +1,98 @@
+package chatgpt_go
+
+import (
+	"sync"
+	"time"
+)
+
+// AuthEventType is the kind of an AuthEvent.
+type AuthEventType string
+
+const (
+	// TokenRefreshed is emitted when a client fetched a new access token.
+	TokenRefreshed AuthEventType = "token_refreshed"
+	// ClearanceRotated is emitted when SetClearanceToken replaced the
+	// clearance token of a client.
+	ClearanceRotated AuthEventType = "clearance_rotated"
+	// AuthFailed is emitted when a client failed to fetch an access token.
+	AuthFailed AuthEventType = "auth_failed"
+)
+
+// AuthEvent is a credential lifecycle event of a client, delivered to the
+// listeners registered with SubscribeAuthEvents.
+type AuthEvent struct {
+	Type   AuthEventType
+	Client *ChatGPT
+	Time   time.Time
+	// Err is the failure of AuthFailed events.
+	Err error
+}
+
+var authListeners = struct {
+	sync.RWMutex
+	next int
+	fns  map[int]func(AuthEvent)
+}{fns: map[int]func(AuthEvent){}}
+
+// SubscribeAuthEvents registers fn to be called with the auth events of
+// every client of the process, e.g. to monitor the credential health of a
+// fleet of clients from one place. fn is called synchronously from the
+// client's goroutine, so it must be quick and safe for concurrent use. The
+// returned func removes the listener.
+func SubscribeAuthEvents(fn func(event AuthEvent)) (unsubscribe func()) {
+	authListeners.Lock()
+	defer authListeners.Unlock()
+	id := authListeners.next
+	authListeners.next++
+	authListeners.fns[id] = fn
+	return func() {
+		authListeners.Lock()
+		defer authListeners.Unlock()
+		delete(authListeners.fns, id)
+	}
+}
+
+func emitAuthEvent(event AuthEvent) {
+	authListeners.RLock()
+	if len(authListeners.fns) == 0 {
+		authListeners.RUnlock()
+		return
+	}
+	fns := make([]func(AuthEvent), 0, len(authListeners.fns))
+	for _, fn := range authListeners.fns {
+		fns = append(fns, fn)
+	}
+	authListeners.RUnlock()
+
+	event.Time = time.Now()
+	for _, fn := range fns {
+		fn(event)
+	}
+}
+
+// SetClearanceToken replaces the Cloudflare clearance token, e.g. after
+// solving a new challenge, and emits a ClearanceRotated event.
+func (c *ChatGPT) SetClearanceToken(token string) {
+	c.tokenMu.Lock()
+	c.ClearanceToken = token
+	c.tokenMu.Unlock()
+	emitAuthEvent(AuthEvent{Type: ClearanceRotated, Client: c})
+}
+
+// UpdateTokens replaces the session and clearance tokens, e.g. after an
+// external solver rotated them, and drops the access token so that the
+// next request gets a new one with them. It waits for a refresh in
+// progress and is safe to call while messages are being sent; requests
+// already sent finish with the old tokens. A ClearanceRotated event is
+// emitted.
+func (c *ChatGPT) UpdateTokens(sessionToken string, clearanceToken string) {
+	c.refreshMu.Lock()
+	c.tokenMu.Lock()
+	c.SessionToken = sessionToken
+	c.ClearanceToken = clearanceToken
+	c.AccessToken = ""
+	c.AccessTokenExpires = time.Time{}
+	c.tokenMu.Unlock()
+	c.refreshMu.Unlock()
+	emitAuthEvent(AuthEvent{Type: ClearanceRotated, Client: c})
+}
@@ -0,0 +1,44 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestSubscribeAuthEvents(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, http.NotFound)
+	failing := newTestClient(t, chatgpt_go.ChatGPTOptions{}, http.NotFound)
+	failing.SessionPath = "/not-found"
+
+	var (
+		mu     sync.Mutex
+		events []chatgpt_go.AuthEvent
+	)
+	unsubscribe := chatgpt_go.SubscribeAuthEvents(func(event chatgpt_go.AuthEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+
+	assert.NoError(t, client.RefreshAccessToken())
+	client.SetClearanceToken("rotated")
+	assert.Equal(t, "rotated", client.ClearanceToken)
+	assert.Error(t, failing.RefreshAccessToken())
+
+	unsubscribe()
+	client.SetClearanceToken("ignored")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, chatgpt_go.TokenRefreshed, events[0].Type)
+		assert.Same(t, client, events[0].Client)
+		assert.Equal(t, chatgpt_go.ClearanceRotated, events[1].Type)
+		assert.Equal(t, chatgpt_go.AuthFailed, events[2].Type)
+		assert.Same(t, failing, events[2].Client)
+		assert.Error(t, events[2].Err)
+	}
+}
@@ -0,0 +1,168 @@
+package chatgpt_go
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const endpointHistory = "history"
+
+// HistoryMessage is a message of a conversation's history.
+type HistoryMessage struct {
+	Id         string
+	Role       string
+	Text       string
+	CreateTime time.Time
+}
+
+// conversationHistory is the response of GET /backend-api/conversation/{id}:
+// the messages form a tree keyed by id, current_node being the last message
+// of the branch shown in the UI.
+type conversationHistory struct {
+	Title       string                 `json:"title"`
+	CurrentNode string                 `json:"current_node"`
+	Mapping     map[string]historyNode `json:"mapping"`
+}
+
+type historyNode struct {
+	Id      string `json:"id"`
+	Message *struct {
+		Id     string `json:"id"`
+		Author struct {
+			Role string `json:"role"`
+		} `json:"author"`
+		CreateTime interface{} `json:"create_time"`
+		Content    struct {
+			ContentType string        `json:"content_type"`
+			Parts       []interface{} `json:"parts"`
+		} `json:"content"`
+	} `json:"message"`
+	Parent   string   `json:"parent"`
+	Children []string `json:"children"`
+}
+
+// GetConversationHistory returns the messages of the conversation in
+// chronological order, from the root of the message tree to its current
+// node. When the conversation has branches, e.g. after a message was
+// edited, the branch currently selected is returned, or the latest one
+// when the backend doesn't tell. Messages without text, such as the hidden
+// root, are skipped.
+func (c *ChatGPT) GetConversationHistory(conversationId string) ([]HistoryMessage, error) {
+	return c.GetConversationHistoryContext(context.Background(), conversationId)
+}
+
+// GetConversationHistoryContext is GetConversationHistory with a context.
+func (c *ChatGPT) GetConversationHistoryContext(ctx context.Context, conversationId string) ([]HistoryMessage, error) {
+	history, err := c.getHistory(ctx, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	return history.messages(), nil
+}
+
+func (c *ChatGPT) getHistory(ctx context.Context, conversationId string) (*conversationHistory, error) {
+	history := &conversationHistory{}
+	if err := c.doJSON(ctx, endpointHistory, http.MethodGet, c.ConversationPath+"/"+conversationId, nil, history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// messages linearizes the branch ending at the current node, or at the
+// latest leaf reached from the root when the current node is unknown.
+func (h *conversationHistory) messages() []HistoryMessage {
+	var branch []historyNode
+	for id, seen := h.leaf(), map[string]bool{}; id != "" && !seen[id]; {
+		node, ok := h.Mapping[id]
+		if !ok {
+			break
+		}
+		seen[id] = true
+		branch = append(branch, node)
+		id = node.Parent
+	}
+
+	var messages []HistoryMessage
+	for i := len(branch) - 1; i >= 0; i-- {
+		m := branch[i].Message
+		if m == nil {
+			continue
+		}
+		var parts []string
+		for _, part := range m.Content.Parts {
+			if s, ok := part.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+		text := strings.Join(parts, "\n")
+		if text == "" {
+			continue
+		}
+		messages = append(messages, HistoryMessage{
+			Id:         m.Id,
+			Role:       m.Author.Role,
+			Text:       text,
+			CreateTime: unixTime(m.CreateTime),
+		})
+	}
+	return messages
+}
+
+// leaf returns the id of the last message of the current branch.
+func (h *conversationHistory) leaf() string {
+	if _, ok := h.Mapping[h.CurrentNode]; ok {
+		return h.CurrentNode
+	}
+	return h.lastLeaf()
+}
+
+// lastLeaf follows the last child from the root of the tree.
+func (h *conversationHistory) lastLeaf() string {
+	id := ""
+	for _, node := range h.Mapping {
+		if node.Parent == "" {
+			id = node.Id
+			break
+		}
+	}
+	for depth := 0; depth < len(h.Mapping); depth++ {
+		node, ok := h.Mapping[id]
+		if !ok || len(node.Children) == 0 {
+			break
+		}
+		id = node.Children[len(node.Children)-1]
+	}
+	return id
+}
+
+// OpenAIMessage is a message in the format of the OpenAI chat completions
+// API.
+type OpenAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ToMessages fetches the conversation's history and returns it in the
+// format of the OpenAI chat completions API, e.g. to carry on the
+// conversation with the official API. Messages of other roles than system,
+// user and assistant, such as tool output, are left out.
+func (c *Conversation) ToMessages() ([]OpenAIMessage, error) {
+	if c.ConversationId == "" {
+		return nil, fmt.Errorf("conversation has no id yet")
+	}
+	history, err := c.ChatGPT.GetConversationHistory(c.ConversationId)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]OpenAIMessage, 0, len(history))
+	for _, m := range history {
+		switch m.Role {
+		case "system", "user", "assistant":
+			messages = append(messages, OpenAIMessage{Role: m.Role, Content: m.Text})
+		}
+	}
+	return messages, nil
+}
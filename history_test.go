@@ -0,0 +1,64 @@
+package chatgpt_go_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// historyResponse is a conversation where the first question was edited:
+// m2 and its reply m3 are an abandoned branch.
+const historyResponse = `{
+	"title": "Greetings",
+	"current_node": "m5",
+	"mapping": {
+		"root": {"id": "root", "message": null, "parent": null, "children": ["m1"]},
+		"m1": {"id": "m1", "message": {"id": "m1", "author": {"role": "system"}, "content": {"content_type": "text", "parts": ["You are helpful."]}}, "parent": "root", "children": ["m2", "m4"]},
+		"m2": {"id": "m2", "message": {"id": "m2", "author": {"role": "user"}, "content": {"content_type": "text", "parts": ["hi"]}}, "parent": "m1", "children": ["m3"]},
+		"m3": {"id": "m3", "message": {"id": "m3", "author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Hi!"]}}, "parent": "m2", "children": []},
+		"m4": {"id": "m4", "message": {"id": "m4", "author": {"role": "user"}, "content": {"content_type": "text", "parts": ["hello"]}, "create_time": 1678000000}, "parent": "m1", "children": ["m5"]},
+		"m5": {"id": "m5", "message": {"id": "m5", "author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Hello!"]}}, "parent": "m4", "children": []}
+	}
+}`
+
+func TestChatGPT_GetConversationHistory(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/backend-api/conversation/c1", r.URL.Path)
+		_, _ = w.Write([]byte(historyResponse))
+	})
+	history, err := client.GetConversationHistory("c1")
+	if assert.NoError(t, err) && assert.Len(t, history, 3) {
+		assert.Equal(t, "m1", history[0].Id)
+		assert.Equal(t, "user", history[1].Role)
+		assert.Equal(t, "hello", history[1].Text)
+		assert.Equal(t, int64(1678000000), history[1].CreateTime.Unix())
+		assert.Equal(t, "Hello!", history[2].Text)
+	}
+
+	messages, err := client.NewConversation("c1", "m5").ToMessages()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []chatgpt_go.OpenAIMessage{
+			{Role: "system", Content: "You are helpful."},
+			{Role: "user", Content: "hello"},
+			{Role: "assistant", Content: "Hello!"},
+		}, messages)
+	}
+}
+
+func TestChatGPT_GetConversationHistory_NoCurrentNode(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Replace(historyResponse, `"current_node": "m5"`, `"current_node": null`, 1)))
+	})
+	history, err := client.GetConversationHistoryContext(context.Background(), "c1")
+	if assert.NoError(t, err) && assert.Len(t, history, 3) {
+		assert.Equal(t, []string{"m1", "m4", "m5"}, []string{history[0].Id, history[1].Id, history[2].Id})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.GetConversationHistoryContext(ctx, "c1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
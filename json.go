@@ -0,0 +1,59 @@
+package chatgpt_go
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// jsonFence matches a markdown code block, optionally tagged json.
+var jsonFence = regexp.MustCompile("(?s)```[a-zA-Z]*\\s*\\n(.*?)\\n?```")
+
+// SendJSON sends prompt asking for a reply in JSON only, shaped as described
+// by schemaHint when it isn't empty, and returns the JSON of the reply. The
+// JSON is extracted from markdown code blocks and surrounding prose. When
+// the reply still isn't valid JSON ErrInvalidJSON is returned, after asking
+// the backend once to fix it when FixInvalidJSON is set.
+//
+// The backend has no JSON mode: this is best effort, the JSON is only
+// checked to parse, not to match schemaHint.
+func (c *Conversation) SendJSON(prompt string, schemaHint string) (json.RawMessage, error) {
+	instruction := prompt + "\n\nRespond only with valid JSON, without any explanation."
+	if schemaHint != "" {
+		instruction += " The JSON must match this schema: " + schemaHint
+	}
+	reply, err := c.SendMessage(instruction)
+	if err != nil {
+		return nil, err
+	}
+	raw, parseErr := extractJSON(reply)
+	if parseErr == nil || !c.ChatGPT.FixInvalidJSON {
+		return raw, parseErr
+	}
+	reply, err = c.SendMessage(fmt.Sprintf("That is not valid JSON (%v). Reply again with only the corrected JSON.", parseErr))
+	if err != nil {
+		return nil, err
+	}
+	return extractJSON(reply)
+}
+
+// extractJSON returns the JSON value of a reply, taken from its first
+// markdown code block if any, else from its first '{' or '[' to its last
+// '}' or ']'.
+func extractJSON(reply string) (json.RawMessage, error) {
+	text := reply
+	if m := jsonFence.FindStringSubmatch(reply); m != nil {
+		text = m[1]
+	} else if start := strings.IndexAny(text, "{["); start >= 0 {
+		if end := strings.LastIndexAny(text, "}]"); end > start {
+			text = text[start : end+1]
+		}
+	}
+	text = strings.TrimSpace(text)
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	return json.RawMessage(text), nil
+}
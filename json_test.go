@@ -0,0 +1,28 @@
+package chatgpt_go
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  string
+	}{
+		{`{"a":1}`, `{"a":1}`},
+		{"```json\n{\"a\": [1, 2]}\n```", `{"a": [1, 2]}`},
+		{"Here you go:\n```\n[1, 2]\n```\nAnything else?", `[1, 2]`},
+		{`Sure! {"a":{"b":true}} Hope it helps.`, `{"a":{"b":true}}`},
+	}
+	for _, tt := range tests {
+		raw, err := extractJSON(tt.reply)
+		if assert.NoError(t, err, tt.reply) {
+			assert.Equal(t, tt.want, string(raw))
+		}
+	}
+	_, err := extractJSON("I can't do that.")
+	assert.ErrorIs(t, err, ErrInvalidJSON)
+	_, err = extractJSON(`{"a":}`)
+	assert.ErrorIs(t, err, ErrInvalidJSON)
+}
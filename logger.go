@@ -0,0 +1,33 @@
+package chatgpt_go
+
+import "github.com/sirupsen/logrus"
+
+// Logger is what a ChatGPT logs through, so that any logging library can be
+// bridged to it. Debug is called with a message and its structured fields,
+// the failure being under "error" when there is one.
+type Logger interface {
+	Debug(msg string, fields map[string]interface{})
+}
+
+// NewLogrusLogger adapts a logrus entry to Logger.
+func NewLogrusLogger(entry *logrus.Entry) Logger {
+	return logrusLogger{entry: entry}
+}
+
+type logrusLogger struct {
+	entry *logrus.Entry
+}
+
+func (l logrusLogger) Debug(msg string, fields map[string]interface{}) {
+	l.entry.WithFields(fields).Debug(msg)
+}
+
+// debug logs msg through the Logger, or through Log when only that is set,
+// which can both be changed after NewChatGPT.
+func (c *ChatGPT) debug(msg string, fields map[string]interface{}) {
+	if c.Logger != nil {
+		c.Logger.Debug(msg, fields)
+	} else if c.Log != nil {
+		NewLogrusLogger(c.Log).Debug(msg, fields)
+	}
+}
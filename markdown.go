@@ -0,0 +1,51 @@
+package chatgpt_go
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdImage      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdBold       = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdItalic     = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*]*?\S)?)\*`)
+	mdStrike     = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	mdInlineCode = regexp.MustCompile("`([^`]+)`")
+	mdHeading    = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`)
+	mdQuote      = regexp.MustCompile(`^\s{0,3}>\s?`)
+)
+
+// StripMarkdown converts markdown formatted text to plain text. Code fences
+// are removed while the code inside is kept, emphasis markers, inline code
+// backticks, heading and quote prefixes are dropped and links are replaced
+// with their text.
+func StripMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	inFence := false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+		out = append(out, stripMarkdownLine(line))
+	}
+	return strings.Join(out, "\n")
+}
+
+func stripMarkdownLine(line string) string {
+	line = mdHeading.ReplaceAllString(line, "")
+	line = mdQuote.ReplaceAllString(line, "")
+	line = mdImage.ReplaceAllString(line, "$1")
+	line = mdLink.ReplaceAllString(line, "$1")
+	line = mdInlineCode.ReplaceAllString(line, "$1")
+	line = mdBold.ReplaceAllString(line, "$2")
+	line = mdStrike.ReplaceAllString(line, "$1")
+	line = mdItalic.ReplaceAllString(line, "$1$2")
+	return line
+}
@@ -0,0 +1,33 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"testing"
+)
+
+func TestStripMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"bold", "this is **bold** and __strong__", "this is bold and strong"},
+		{"italic", "an *emphasized* word", "an emphasized word"},
+		{"bullet", "* item one\n* item two", "* item one\n* item two"},
+		{"inline code", "run `go test` now", "run go test now"},
+		{"link", "see [the docs](https://example.com)", "see the docs"},
+		{"image", "![logo](https://example.com/a.png)", "logo"},
+		{"heading", "## Title\ntext", "Title\ntext"},
+		{"quote", "> quoted", "quoted"},
+		{"fence", "code:\n```go\nfmt.Println(\"**x**\")\n```\ndone", "code:\nfmt.Println(\"**x**\")\ndone"},
+		{"unclosed bold", "partial **bo", "partial **bo"},
+		{"snake case", "use snake_case_names", "use snake_case_names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, chatgpt_go.StripMarkdown(tt.in))
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package chatgpt_go
+
+import "time"
+
+const (
+	endpointSession      = "session"
+	endpointConversation = "conversation"
+)
+
+// Metrics receives measurements about the requests made by a ChatGPT. It
+// can be implemented with prometheus/client_golang or any other metrics
+// library without the package depending on it.
+type Metrics interface {
+	// ObserveRequest is called once per HTTP attempt with the endpoint name,
+	// the response status code (0 when no response was received) and the
+	// time it took to receive the response headers.
+	ObserveRequest(endpoint string, statusCode int, latency time.Duration)
+	// IncRetry is called every time a request to endpoint is retried.
+	IncRetry(endpoint string)
+}
@@ -0,0 +1,51 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordMetrics struct {
+	mu       sync.Mutex
+	requests []string
+	statuses []int
+	retries  map[string]int
+}
+
+func (m *recordMetrics) ObserveRequest(endpoint string, statusCode int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, endpoint)
+	m.statuses = append(m.statuses, statusCode)
+}
+
+func (m *recordMetrics) IncRetry(endpoint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[endpoint]++
+}
+
+func TestChatGPT_Metrics(t *testing.T) {
+	metrics := &recordMetrics{retries: map[string]int{}}
+	calls := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{Metrics: metrics, MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	assert.Equal(t, []string{"session", "conversation", "conversation"}, metrics.requests)
+	assert.Equal(t, []int{http.StatusOK, http.StatusServiceUnavailable, http.StatusOK}, metrics.statuses)
+	assert.Equal(t, map[string]int{"conversation": 1}, metrics.retries)
+}
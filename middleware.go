@@ -0,0 +1,163 @@
+package chatgpt_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SendFunc sends body on conversation and returns the final result of the
+// response. It is the send pipeline that middlewares wrap.
+type SendFunc func(ctx context.Context, conversation *Conversation, body *ConversationBody) (*ConversationResult, error)
+
+// Middleware wraps the send pipeline, e.g. to log, cache or rate limit
+// sends. It is called with the next SendFunc of the pipeline and returns
+// the one to call instead.
+type Middleware func(next SendFunc) SendFunc
+
+// Use appends middlewares to the send pipeline of every conversation of the
+// client. The first one registered is the outermost. They run once per
+// send, around the built-in steps, from the outermost: ResponseCache,
+// ModelFallbacks, RestartLockedConversations, CorrectParentMessage, the
+// access token refresh and MaxRetries.
+func (c *ChatGPT) Use(middlewares ...Middleware) *ChatGPT {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+	return c
+}
+
+// LoggerMiddleware logs every send with its duration and outcome through
+// log.
+func LoggerMiddleware(log Logger) Middleware {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, conversation *Conversation, body *ConversationBody) (*ConversationResult, error) {
+			start := time.Now()
+			result, err := next(ctx, conversation, body)
+			fields := map[string]interface{}{
+				"action":          body.Action,
+				"conversation_id": conversation.ConversationId,
+				"duration":        time.Since(start),
+			}
+			if err != nil {
+				fields["error"] = err
+				log.Debug("send failed", fields)
+			} else {
+				log.Debug("send", fields)
+			}
+			return result, err
+		}
+	}
+}
+
+// refreshToken refreshes the access token of the client, if it is missing
+// or expired, before sending.
+func refreshToken(next SendFunc) SendFunc {
+	return func(ctx context.Context, c *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		if err := c.ChatGPT.RefreshAccessTokenContext(ctx); err != nil {
+			return nil, fmt.Errorf("refresh access token: %w", err)
+		}
+		return next(ctx, c, body)
+	}
+}
+
+// retrySends implements MaxRetries for sends: a send failing before its
+// response streams, e.g. with a 5xx or a network error, is sent again after
+// a backoff. Once the reply streams a failure isn't retried, as the reply
+// may already have been delivered in part.
+func retrySends(next SendFunc) SendFunc {
+	return func(ctx context.Context, c *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		client := c.ChatGPT
+		for attempt := 0; ; attempt++ {
+			sent := &sendAttempt{}
+			result, err := next(context.WithValue(ctx, sendAttemptKey{}, sent), c, body)
+			if err == nil || sent.streamed || !client.retryableSend(ctx, err) {
+				return result, err
+			}
+			var retryAfter time.Duration
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) {
+				retryAfter = statusErr.RetryAfter
+			}
+			url := client.BaseURL + client.ConversationPath
+			delay, ok := client.retryWait(ctx, url, attempt, retryAfter)
+			if !ok {
+				return result, err
+			}
+			if err := client.sleepRetry(ctx, endpointConversation, url, attempt, delay, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// restartLocked implements RestartLockedConversations: a message refused
+// because the conversation is locked is sent again in a new conversation.
+func restartLocked(next SendFunc) SendFunc {
+	return func(ctx context.Context, c *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		result, err := next(ctx, c, body)
+		if errors.Is(err, ErrConversationLocked) && c.ChatGPT.RestartLockedConversations && body.Action == "next" && c.ConversationId != "" {
+			c.ChatGPT.debug("restart locked conversation", map[string]interface{}{"error": err, "conversation_id": c.ConversationId})
+			c.ConversationId = ""
+			c.ParentMessageId = ""
+			body.ConversationId = ""
+			body.ParentMessageId = ""
+			return next(ctx, c, body)
+		}
+		return result, err
+	}
+}
+
+// fallbackModels implements ModelFallbacks: a message refused because the
+// model is overloaded or capped is sent again to the next fallback model.
+func fallbackModels(next SendFunc) SendFunc {
+	return func(ctx context.Context, c *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		result, err := next(ctx, c, body)
+		tried := map[string]bool{body.Model: true}
+		for _, model := range c.ChatGPT.ModelFallbacks {
+			if !errors.Is(err, ErrModelOverloaded) && !errors.Is(err, ErrMessageCapReached) {
+				break
+			}
+			if tried[model] {
+				continue
+			}
+			tried[model] = true
+			c.ChatGPT.debug("fall back to model", map[string]interface{}{"error": err, "model": model})
+			body.Model = model
+			result, err = next(ctx, c, body)
+			if err == nil && result.Message.Metadata.ModelSlug == "" {
+				c.servedModel = model
+			}
+		}
+		return result, err
+	}
+}
+
+// correctParent implements CorrectParentMessage: a message whose parent was
+// rejected is sent once more after the latest message of the conversation.
+func correctParent(next SendFunc) SendFunc {
+	return func(ctx context.Context, c *Conversation, body *ConversationBody) (*ConversationResult, error) {
+		result, err := next(ctx, c, body)
+		if !errors.Is(err, ErrInvalidParent) || !c.ChatGPT.CorrectParentMessage || body.Action != "next" || c.ConversationId == "" {
+			return result, err
+		}
+		history, historyErr := c.ChatGPT.getHistory(ctx, c.ConversationId)
+		if historyErr != nil {
+			c.ChatGPT.debug("correct parent message", map[string]interface{}{"error": historyErr})
+			return result, err
+		}
+		rejected, corrected := body.ParentMessageId, history.leaf()
+		if corrected == "" || corrected == rejected {
+			return result, err
+		}
+		c.ChatGPT.debug("correct parent message", map[string]interface{}{"rejected": rejected, "corrected": corrected})
+		c.ParentMessageId = corrected
+		body.ParentMessageId = corrected
+		result, err = next(ctx, c, body)
+		if err == nil && c.ChatGPT.OnParentCorrected != nil {
+			c.ChatGPT.OnParentCorrected(c, rejected, corrected)
+		}
+		return result, err
+	}
+}
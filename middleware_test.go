@@ -0,0 +1,228 @@
+package chatgpt_go_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_Use(t *testing.T) {
+	sends := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+
+	var calls []string
+	trace := func(name string) chatgpt_go.Middleware {
+		return func(next chatgpt_go.SendFunc) chatgpt_go.SendFunc {
+			return func(ctx context.Context, conversation *chatgpt_go.Conversation, body *chatgpt_go.ConversationBody) (*chatgpt_go.ConversationResult, error) {
+				calls = append(calls, name+" "+body.Action)
+				return next(ctx, conversation, body)
+			}
+		}
+	}
+	cache := map[string]*chatgpt_go.ConversationResult{}
+	caching := func(next chatgpt_go.SendFunc) chatgpt_go.SendFunc {
+		return func(ctx context.Context, conversation *chatgpt_go.Conversation, body *chatgpt_go.ConversationBody) (*chatgpt_go.ConversationResult, error) {
+			prompt := body.Messages[0].Content.Parts[0]
+			if result, ok := cache[prompt]; ok {
+				return result, nil
+			}
+			result, err := next(ctx, conversation, body)
+			if err == nil {
+				cache[prompt] = result
+			}
+			return result, err
+		}
+	}
+	client.Use(trace("outer"), trace("inner")).Use(caching)
+
+	conversation := client.NewConversation("", "")
+	for i := 0; i < 2; i++ {
+		resp, err := conversation.SendMessage("hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", resp)
+	}
+	assert.Equal(t, []string{"outer next", "inner next", "outer next", "inner next"}, calls)
+	assert.Equal(t, 1, sends)
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	fail := false
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	logger := &recordLogger{}
+	client.Use(chatgpt_go.LoggerMiddleware(logger))
+
+	conversation := client.NewConversation("", "")
+	_, err := conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	fail = true
+	_, err = conversation.SendMessage("again")
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"send", "send failed"}, logger.msgs)
+	assert.Equal(t, "next", logger.fields[0]["action"])
+	assert.Equal(t, "c1", logger.fields[0]["conversation_id"])
+	assert.Nil(t, logger.fields[0]["error"])
+	assert.Equal(t, err, logger.fields[1]["error"])
+}
+
+func TestChatGPT_Use_BuiltinRetry(t *testing.T) {
+	sends := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		if sends++; sends == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	// MaxRetries is the innermost step of the pipeline: a middleware sees
+	// the send once, however many attempts it took
+	calls := 0
+	var tokens []string
+	client.Use(func(next chatgpt_go.SendFunc) chatgpt_go.SendFunc {
+		return func(ctx context.Context, conversation *chatgpt_go.Conversation, body *chatgpt_go.ConversationBody) (*chatgpt_go.ConversationResult, error) {
+			calls++
+			tokens = append(tokens, conversation.ChatGPT.AccessToken)
+			return next(ctx, conversation, body)
+		}
+	})
+
+	resp, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", resp)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 2, sends)
+	// the access token is refreshed inside the pipeline too
+	assert.Equal(t, []string{""}, tokens)
+	assert.Equal(t, "test-token", client.AccessToken)
+}
+
+func TestChatGPT_BuiltinRetry_Streamed(t *testing.T) {
+	sends := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRetries: 2, RetryBackoff: time.Millisecond}, func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		w.Header().Set("content-type", "text/event-stream")
+		_, _ = w.Write([]byte("data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"h\"]}},\"conversation_id\":\"c1\"}\n\n"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if assert.NoError(t, err) {
+			_ = conn.Close()
+		}
+	})
+	// the reply was cut after it started: it isn't sent again
+	_, err := client.NewConversation("", "").SendMessageStream("hello", func(string) {})
+	assert.Error(t, err)
+	assert.True(t, chatgpt_go.IsRetryable(err), err)
+	assert.Equal(t, 1, sends)
+}
+
+func TestChatGPT_Use_Retry(t *testing.T) {
+	sends := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		sends++
+		if sends < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	// MaxRetries is 0: sends are retried by the middleware only
+	attempts := 0
+	client.Use(func(next chatgpt_go.SendFunc) chatgpt_go.SendFunc {
+		return func(ctx context.Context, conversation *chatgpt_go.Conversation, body *chatgpt_go.ConversationBody) (*chatgpt_go.ConversationResult, error) {
+			for {
+				attempts++
+				result, err := next(ctx, conversation, body)
+				if err == nil || !chatgpt_go.IsRetryable(err) || attempts == 3 {
+					return result, err
+				}
+			}
+		}
+	})
+
+	resp, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", resp)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 3, sends)
+}
+
+func TestChatGPT_ModelFallbacks(t *testing.T) {
+	var models []string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{ModelFallbacks: []string{"gpt-4", "text-davinci-002-render-sha"}}, func(w http.ResponseWriter, r *http.Request) {
+		body := chatgpt_go.ConversationBody{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		models = append(models, body.Model)
+		if body.Model == "gpt-4" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"detail":{"code":"model_cap_exceeded","clears_in":60}}`))
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "").SetModel("gpt-4")
+	message, err := conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", message)
+	assert.Equal(t, []string{"gpt-4", "text-davinci-002-render-sha"}, models)
+	assert.Equal(t, "text-davinci-002-render-sha", conversation.ServedModel())
+
+	models = nil
+	client.ModelFallbacks = []string{"gpt-4"}
+	_, err = client.NewConversation("", "").SetModel("gpt-4").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrMessageCapReached)
+	assert.Equal(t, []string{"gpt-4"}, models)
+}
+
+func TestChatGPT_CorrectParentMessage(t *testing.T) {
+	var parents []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backend-api/conversation/c1":
+			_, _ = w.Write([]byte(historyResponse))
+		case "/backend-api/conversation":
+			body := chatgpt_go.ConversationBody{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			parents = append(parents, body.ParentMessageId)
+			if body.ParentMessageId != "m5" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"detail":"Parent message not found"}`))
+				return
+			}
+			writeStream(w, `{"message":{"id":"m6","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+		}
+	}
+
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler)
+	_, err := client.NewConversation("c1", "m3").SendMessage("hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrInvalidParent)
+	assert.Equal(t, []string{"m3"}, parents)
+
+	parents = nil
+	var corrections []string
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{
+		CorrectParentMessage: true,
+		OnParentCorrected: func(conversation *chatgpt_go.Conversation, rejected string, corrected string) {
+			corrections = append(corrections, rejected+">"+corrected)
+		},
+	}, handler)
+	conversation := client.NewConversation("c1", "m3")
+	message, err := conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", message)
+	assert.Equal(t, "m6", conversation.ParentMessageId)
+	assert.Equal(t, []string{"m3", "m5"}, parents)
+	assert.Equal(t, []string{"m3>m5"}, corrections)
+}
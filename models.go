@@ -0,0 +1,80 @@
+package chatgpt_go
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	endpointModels = "models"
+	// modelsRetryAfter is how long a failure to list the models is reused
+	// before asking the backend again.
+	modelsRetryAfter = time.Minute
+)
+
+// Model is a model available to the account.
+type Model struct {
+	Slug         string                 `json:"slug"`
+	MaxTokens    int                    `json:"max_tokens"`
+	Title        string                 `json:"title"`
+	Description  string                 `json:"description"`
+	Tags         []string               `json:"tags"`
+	Capabilities map[string]interface{} `json:"capabilities"`
+}
+
+// Supports reports whether the model supports the conversation action
+// (e.g. "continue"). Actions missing from the capabilities are assumed to
+// be supported.
+func (m Model) Supports(action string) bool {
+	if supported, ok := m.Capabilities[action].(bool); ok {
+		return supported
+	}
+	return true
+}
+
+type modelsResult struct {
+	Models []Model `json:"models"`
+}
+
+// ListModels returns the models available to the account. The list is
+// cached after the first successful call, and a failure for a minute.
+func (c *ChatGPT) ListModels() ([]Model, error) {
+	c.mu.Lock()
+	models, modelsErr, failedAt := c.models, c.modelsErr, c.modelsFailedAt
+	c.mu.Unlock()
+	if models != nil {
+		return models, nil
+	}
+	if modelsErr != nil && c.clock().Sub(failedAt) < modelsRetryAfter {
+		return nil, modelsErr
+	}
+
+	result := modelsResult{}
+	err := c.doJSON(context.Background(), endpointModels, http.MethodGet, c.ModelsPath, nil, &result)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.modelsErr, c.modelsFailedAt = err, c.clock()
+		return nil, err
+	}
+	c.models = result.Models
+	c.modelsErr = nil
+	return result.Models, nil
+}
+
+// modelSupports reports whether model supports action. When the models
+// can't be listed or the model isn't in the list the action is attempted.
+func (c *ChatGPT) modelSupports(model string, action string) bool {
+	models, err := c.ListModels()
+	if err != nil {
+		c.debug("list models", map[string]interface{}{"error": err})
+		return true
+	}
+	for _, m := range models {
+		if m.Slug == model {
+			return m.Supports(action)
+		}
+	}
+	return true
+}
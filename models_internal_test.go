@@ -0,0 +1,46 @@
+package chatgpt_go
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_ListModels_RetryAfter(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == defaultSessionPath {
+			_, _ = fmt.Fprintf(w, `{"accessToken":"token","expires":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"models":[{"slug":"gpt-4"}]}`))
+	}))
+	defer server.Close()
+	c, err := NewChatGPT(ChatGPTOptions{SessionToken: "session", ClearanceToken: "clearance", UserAgent: "agent", BaseURL: server.URL})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err = c.ListModels()
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(modelsRetryAfter)
+	models, err := c.ListModels()
+	if assert.NoError(t, err) && assert.Len(t, models, 1) {
+		assert.Equal(t, "gpt-4", models[0].Slug)
+	}
+	assert.Equal(t, 2, calls)
+}
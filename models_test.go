@@ -0,0 +1,141 @@
+package chatgpt_go_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+)
+
+func TestConversation_ContinueGeneration(t *testing.T) {
+	modelCalls := 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backend-api/models":
+			modelCalls++
+			_, _ = w.Write([]byte(`{"models":[
+				{"slug":"text-davinci-002-render","capabilities":{}},
+				{"slug":"gpt-4-plugins","capabilities":{"continue":false}}
+			]}`))
+		case "/backend-api/conversation":
+			body := map[string]interface{}{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "continue", body["action"])
+			assert.Equal(t, "m1", body["parent_message_id"])
+			assert.Nil(t, body["messages"])
+			writeStream(w, `{"message":{"id":"m2","content":{"parts":["the rest"]},"metadata":{"model_slug":"gpt-4-plugins"}},"conversation_id":"c1"}`)
+		}
+	})
+
+	conversation := client.NewConversation("c1", "m1")
+	resp, err := conversation.ContinueGeneration()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "the rest", resp)
+		assert.Equal(t, "m2", conversation.ParentMessageId)
+	}
+
+	// the conversation is now served by a model that can't continue
+	_, err = conversation.ContinueGeneration()
+	assert.ErrorIs(t, err, chatgpt_go.ErrActionNotSupported)
+	assert.Equal(t, 1, modelCalls)
+
+	_, err = client.NewConversation("", "").ContinueGeneration()
+	assert.Error(t, err)
+}
+
+func TestConversation_ContinueGeneration_Truncated(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["action"] == "continue" {
+			assert.Equal(t, "m1", body["parent_message_id"])
+			writeStream(w, `{"message":{"id":"m2","content":{"parts":[" and the end"]},"end_turn":true,"metadata":{"finish_details":{"type":"stop"}}},"conversation_id":"c1"}`)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["the start"]},"end_turn":false,"metadata":{"finish_details":{"type":"max_tokens"}}},"conversation_id":"c1"}`)
+	})
+
+	conversation := client.NewConversation("", "")
+	resp, err := conversation.SendMessage("hi")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "the start", resp)
+		assert.True(t, conversation.Truncated())
+	}
+
+	resp, err = conversation.ContinueGeneration()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "the start and the end", resp)
+		assert.False(t, conversation.Truncated())
+	}
+}
+
+func TestConversation_ContinueGeneration_Processed(t *testing.T) {
+	continues := 0
+	var parents []interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{RolePrefix: chatgpt_go.DefaultRolePrefix}, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/backend-api/conversation" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		parents = append(parents, body["parent_message_id"])
+		if body["action"] != "continue" {
+			writeStream(w, `{"message":{"id":"m1","content":{"parts":["Assistant: the start"]},"end_turn":false,"metadata":{"finish_details":{"type":"max_tokens"}}},"conversation_id":"c1"}`)
+			return
+		}
+		if continues++; continues == 1 {
+			writeStream(w, `{"message":{"id":"m2","content":{"parts":["Something went wrong."]}},"conversation_id":"c1"}`)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m3","content":{"parts":[" and the end"]},"end_turn":true,"metadata":{"finish_details":{"type":"stop"}}},"conversation_id":"c1"}`)
+	})
+
+	conversation := client.NewConversation("", "")
+	resp, err := conversation.SendMessage("hi")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "the start", resp)
+	}
+
+	// a failed continuation leaves the reply to continue as it was
+	_, err = conversation.ContinueGeneration()
+	assert.ErrorIs(t, err, chatgpt_go.ErrBackendGlitch)
+	assert.True(t, conversation.Truncated())
+	assert.Equal(t, "m1", conversation.ParentMessageId)
+
+	resp, err = conversation.ContinueGeneration()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "the start and the end", resp)
+		assert.False(t, conversation.Truncated())
+		assert.Equal(t, "m3", conversation.ParentMessageId)
+	}
+	// both continuations were of m1, not of the glitched m2
+	assert.Equal(t, []interface{}{parents[0], "m1", "m1"}, parents)
+}
+
+func TestChatGPT_ListModels_Failure(t *testing.T) {
+	modelCalls, sends := 0, 0
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{ModelsPath: "/v1/models"}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models":
+			modelCalls++
+			w.WriteHeader(http.StatusNotFound)
+		case "/backend-api/conversation":
+			sends++
+			writeStream(w, `{"message":{"id":"m2","content":{"parts":["the rest"]}},"conversation_id":"c1"}`)
+		}
+	})
+
+	// the failure is cached and the action attempted anyway
+	conversation := client.NewConversation("c1", "m1")
+	for i := 0; i < 3; i++ {
+		_, err := conversation.ContinueGeneration()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, sends)
+	assert.Equal(t, 1, modelCalls)
+	_, err := client.ListModels()
+	assert.Error(t, err)
+	assert.Equal(t, 1, modelCalls)
+}
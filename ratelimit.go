@@ -0,0 +1,13 @@
+package chatgpt_go
+
+import "context"
+
+// RateLimiter throttles the requests of a client, e.g. to share a request
+// rate between many conversations sent in parallel. *rate.Limiter of
+// golang.org/x/time/rate implements it without the package depending on
+// it. Implementations must be safe for concurrent use.
+type RateLimiter interface {
+	// Wait blocks until a request may be sent. The request isn't sent
+	// when it returns an error, e.g. because ctx is done.
+	Wait(ctx context.Context) error
+}
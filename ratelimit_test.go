@@ -0,0 +1,47 @@
+package chatgpt_go_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+type limiterFunc func(ctx context.Context) error
+
+func (f limiterFunc) Wait(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestChatGPT_RateLimiter(t *testing.T) {
+	var waits, requests int32
+	var limitErr error
+	limiter := limiterFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&waits, 1)
+		return limitErr
+	})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{RateLimiter: limiter, MaxRetries: 1, RetryBackoff: 1}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+
+	assert.NoError(t, client.RefreshAccessToken())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&waits))
+
+	// the retry waits too
+	_, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&waits))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	limitErr = errors.New("limit exceeded")
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.ErrorIs(t, err, limitErr)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
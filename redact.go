@@ -0,0 +1,20 @@
+package chatgpt_go
+
+import "regexp"
+
+var (
+	emailRegexp      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	ssnRegexp        = regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)
+	creditCardRegexp = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+)
+
+// RedactPII replaces email addresses, US social security numbers and credit
+// card numbers in s with placeholders. It is meant to be used as the
+// Redactor option. The patterns are simple: data written in other formats
+// goes through unchanged.
+func RedactPII(s string) string {
+	s = emailRegexp.ReplaceAllString(s, "[EMAIL]")
+	s = ssnRegexp.ReplaceAllString(s, "[SSN]")
+	s = creditCardRegexp.ReplaceAllString(s, "[CARD]")
+	return s
+}
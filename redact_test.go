@@ -0,0 +1,20 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"testing"
+)
+
+func TestRedactPII(t *testing.T) {
+	tests := map[string]string{
+		"mail me at jane.doe+x@example.co.uk": "mail me at [EMAIL]",
+		"my ssn is 123-45-6789.":              "my ssn is [SSN].",
+		"card 4111 1111 1111 1111 exp 12/25":  "card [CARD] exp 12/25",
+		"card 4111-1111-1111-1111":            "card [CARD]",
+		"call 555-1234 at 10:30":              "call 555-1234 at 10:30",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, chatgpt_go.RedactPII(in))
+	}
+}
@@ -0,0 +1,69 @@
+package chatgpt_go
+
+import (
+	"context"
+	"time"
+)
+
+// autoRefreshRetry is how long StartAutoRefresh waits after a failed refresh.
+const autoRefreshRetry = 30 * time.Second
+
+// clock returns the current time used for the access token lifecycle, which
+// tests replace through the now field.
+func (c *ChatGPT) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// ExpiresWithin reports whether the access token expires within d. It is
+// true when no token has been fetched yet.
+func (c *ChatGPT) ExpiresWithin(d time.Duration) bool {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
+	return c.AccessToken == "" || c.AccessTokenExpires.Sub(c.clock()) <= d
+}
+
+// StartAutoRefresh refreshes the access token in the background whenever it
+// expires within ahead, so sends don't pay for the refresh, until ctx is
+// done. Failed refreshes are logged and retried after 30 seconds.
+func (c *ChatGPT) StartAutoRefresh(ctx context.Context, ahead time.Duration) {
+	go func() {
+		for {
+			wait, err := c.autoRefresh(ctx, ahead)
+			if err != nil {
+				c.debug("auto refresh access token error", map[string]interface{}{"error": err})
+				wait = autoRefreshRetry
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// autoRefresh refreshes the access token if it expires within ahead and
+// returns how long to wait before it does.
+func (c *ChatGPT) autoRefresh(ctx context.Context, ahead time.Duration) (time.Duration, error) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if c.ExpiresWithin(ahead) {
+		if err := c.refreshAccessToken(ctx, true); err != nil {
+			return 0, err
+		}
+	}
+	c.tokenMu.RLock()
+	wait := c.AccessTokenExpires.Sub(c.clock()) - ahead
+	c.tokenMu.RUnlock()
+	if wait < time.Second {
+		// A token expiring within ahead right after a refresh would
+		// otherwise make this spin.
+		wait = autoRefreshRetry
+	}
+	return wait, nil
+}
@@ -0,0 +1,27 @@
+package chatgpt_go
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_TokenExpiry(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := &ChatGPT{
+		AccessToken:        "token",
+		AccessTokenExpires: now.Add(time.Hour),
+		now:                func() time.Time { return now },
+	}
+	assert.False(t, c.IsAccessTokenExpired())
+	assert.True(t, c.ExpiresWithin(time.Hour))
+	assert.False(t, c.ExpiresWithin(time.Hour-time.Nanosecond))
+
+	wait, err := c.autoRefresh(context.Background(), 10*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Minute, wait)
+
+	now = now.Add(time.Hour + time.Nanosecond)
+	assert.True(t, c.IsAccessTokenExpired())
+}
@@ -0,0 +1,131 @@
+package chatgpt_go_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_ExpiresWithin(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, http.NotFound)
+	assert.True(t, client.ExpiresWithin(0))
+
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{
+		AccessToken:        "seeded",
+		AccessTokenExpires: time.Now().Add(time.Hour),
+	}, http.NotFound)
+	assert.False(t, client.ExpiresWithin(time.Minute))
+	assert.True(t, client.ExpiresWithin(2*time.Hour))
+}
+
+func TestChatGPT_StartAutoRefresh(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{
+		AccessToken:        "seeded",
+		AccessTokenExpires: time.Now().Add(time.Minute),
+	}, http.NotFound)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.StartAutoRefresh(ctx, 5*time.Minute)
+
+	// The mock session endpoint hands out tokens valid for an hour.
+	assert.Eventually(t, func() bool {
+		return !client.ExpiresWithin(30 * time.Minute)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestChatGPT_RefreshAccessToken_Concurrent(t *testing.T) {
+	var sessionCalls int32
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sessionCalls, 1)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = fmt.Fprintf(w, `{"accessToken":"shared-token","expires":"%s"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	// route the session requests to the counting handler
+	client.SessionPath = "/counted-session"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.RefreshAccessToken())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sessionCalls))
+	assert.Equal(t, "shared-token", client.AccessToken)
+}
+
+func TestChatGPT_RefreshAccessToken_SessionExpired(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusUnauthorized, `{"detail":"unauthorized"}`},
+		{http.StatusForbidden, ``},
+		{http.StatusOK, `{}`},
+		{http.StatusOK, `{"error":"RefreshAccessTokenError"}`},
+	} {
+		client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			_, _ = w.Write([]byte(tt.body))
+		})
+		client.SessionPath = "/expired-session"
+		assert.ErrorIs(t, client.RefreshAccessToken(), chatgpt_go.ErrSessionExpired, tt.body)
+	}
+
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	client.SessionPath = "/unavailable-session"
+	err := client.RefreshAccessToken()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, chatgpt_go.ErrSessionExpired)
+}
+
+func TestChatGPT_UpdateTokens(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		cookies []string
+	)
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rotating-session" {
+			mu.Lock()
+			cookies = append(cookies, r.Header.Get("cookie"))
+			n := len(cookies)
+			mu.Unlock()
+			_, _ = fmt.Fprintf(w, `{"accessToken":"token-%d","expires":"%s"}`, n, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	client.SessionPath = "/rotating-session"
+	assert.NoError(t, client.RefreshAccessToken())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.NewConversation("", "").SendMessage("hello")
+			assert.NoError(t, err)
+		}()
+	}
+	client.UpdateTokens("new-session", "new-clearance")
+	wg.Wait()
+
+	assert.NoError(t, client.RefreshAccessToken())
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "cf_clearance=clearance; __Secure-next-auth.session-token=session", cookies[0])
+		assert.Equal(t, "cf_clearance=new-clearance; __Secure-next-auth.session-token=new-session", cookies[1])
+	}
+}
@@ -0,0 +1,158 @@
+package chatgpt_go
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const defaultMaxReconnects = 3
+
+// REPLOptions configures Conversation.REPL.
+type REPLOptions struct {
+	// Prompt is written before reading every message, "> " by default.
+	Prompt string
+	// MaxReconnects caps how many times a turn is retried after the
+	// connection dropped, 3 by default. ReconnectDelay is the wait before
+	// every attempt, 1s by default.
+	MaxReconnects  int
+	ReconnectDelay time.Duration
+	// OnStatus, when set, is called with status updates such as
+	// "reconnecting (1/3)..." to show in the UI.
+	OnStatus func(status string)
+}
+
+// REPL runs an interactive session on the conversation: every line read
+// from in is sent as a message and the reply is streamed to out. It returns
+// when in is exhausted or ctx is done, also while waiting for a line: the
+// read in progress is then abandoned. Failed turns are reported on out and
+// the session goes on.
+//
+// When the connection drops during a turn it is retried: a reply cut short
+// is resumed where it stopped with a "continue" action, a message whose
+// reply never started is sent again.
+func (c *Conversation) REPL(ctx context.Context, in io.Reader, out io.Writer, options REPLOptions) error {
+	if options.Prompt == "" {
+		options.Prompt = "> "
+	}
+	if options.MaxReconnects <= 0 {
+		options.MaxReconnects = defaultMaxReconnects
+	}
+	if options.ReconnectDelay <= 0 {
+		options.ReconnectDelay = time.Second
+	}
+	lines, readErr := readLines(ctx, in)
+	for {
+		if _, err := io.WriteString(out, options.Prompt); err != nil {
+			return err
+		}
+		var line string
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case l, ok := <-lines:
+			if !ok {
+				return <-readErr
+			}
+			line = l
+		}
+		prompt := strings.TrimSpace(line)
+		if prompt == "" {
+			continue
+		}
+		if err := c.replTurn(ctx, prompt, out, options); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if _, err := fmt.Fprintf(out, "\nerror: %v\n", err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readLines reads the lines of in in the background, so that waiting for
+// one can be given up when ctx is done. The lines channel is closed once in
+// is exhausted, after the error of reading it, if any, is sent on errc.
+func readLines(ctx context.Context, in io.Reader) (<-chan string, <-chan error) {
+	lines := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		errc <- scanner.Err()
+	}()
+	return lines, errc
+}
+
+// replTurn sends prompt and streams the reply to out, reconnecting when the
+// connection drops.
+func (c *Conversation) replTurn(ctx context.Context, prompt string, out io.Writer, options REPLOptions) error {
+	body := c.nextBody(prompt)
+	for attempt := 1; ; attempt++ {
+		var (
+			partial  *ConversationResult
+			writeErr error
+		)
+		ts := c.ChatGPT.newTextStream()
+		write := func(delta string) bool {
+			if delta != "" && writeErr == nil {
+				_, writeErr = io.WriteString(out, delta)
+			}
+			return writeErr == nil
+		}
+		result, err := c.send(ctx, body, streamHandler{onResult: func(r *ConversationResult) bool {
+			partial = r
+			return write(ts.update(r.text(), false))
+		}})
+		if err == nil {
+			if _, err := result.message(); err != nil {
+				return err
+			}
+			write(ts.update(result.text(), true))
+			write("\n")
+			return writeErr
+		}
+		if partial != nil {
+			write(ts.update(partial.text(), true))
+		}
+		if writeErr != nil {
+			return writeErr
+		}
+		if attempt > options.MaxReconnects || ctx.Err() != nil || !IsRetryable(err) {
+			return err
+		}
+
+		if options.OnStatus != nil {
+			options.OnStatus(fmt.Sprintf("reconnecting (%d/%d)...", attempt, options.MaxReconnects))
+		}
+		c.ChatGPT.debug("repl reconnect", map[string]interface{}{"error": err, "attempt": attempt})
+		timer := time.NewTimer(options.ReconnectDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if partial != nil && partial.Message.Id != "" && partial.ConversationId != "" {
+			// resume the reply where it was cut
+			c.ConversationId = partial.ConversationId
+			c.ParentMessageId = partial.Message.Id
+			if !c.ChatGPT.modelSupports(c.model(), "continue") {
+				return err
+			}
+			body = &ConversationBody{Action: "continue"}
+		}
+	}
+}
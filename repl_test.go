@@ -0,0 +1,73 @@
+package chatgpt_go_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConversation_REPL_Reconnect(t *testing.T) {
+	var bodies []map[string]interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backend-api/conversation" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if len(bodies) == 1 {
+			w.Header().Set("content-type", "text/event-stream")
+			_, _ = fmt.Fprint(w, `data: {"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`+"\n\n")
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		writeStream(w, `{"message":{"id":"m2","content":{"parts":["lo"]}},"conversation_id":"c1"}`)
+	})
+
+	var out bytes.Buffer
+	var statuses []string
+	err := client.NewConversation("", "").REPL(context.Background(), strings.NewReader("hi\n"), &out, chatgpt_go.REPLOptions{
+		ReconnectDelay: time.Millisecond,
+		OnStatus:       func(status string) { statuses = append(statuses, status) },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "> Hello\n> ", out.String())
+	assert.Equal(t, []string{"reconnecting (1/3)..."}, statuses)
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, "continue", bodies[1]["action"])
+		assert.Equal(t, "c1", bodies[1]["conversation_id"])
+		assert.Equal(t, "m1", bodies[1]["parent_message_id"])
+	}
+}
+
+func TestConversation_REPL_CancelWhileReading(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("nothing should be sent")
+	})
+	in, stdin := io.Pipe()
+	defer func() { _ = stdin.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- client.NewConversation("", "").REPL(ctx, in, io.Discard, chatgpt_go.REPLOptions{})
+	}()
+
+	// no line is ever typed: cancelling must stop the session anyway
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("REPL did not return after cancel")
+	}
+}
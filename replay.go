@@ -0,0 +1,61 @@
+package chatgpt_go
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BuildRequest builds the request SendMessage would post for message
+// without sending it, e.g. to persist it in a queue and send it later with
+// ReplayRequest. The conversation isn't advanced.
+func (c *Conversation) BuildRequest(message string) (*http.Request, error) {
+	if err := c.ChatGPT.RefreshAccessToken(); err != nil {
+		return nil, fmt.Errorf("refresh access token: %w", err)
+	}
+	return c.buildRequest(context.Background(), c.nextBody(message))
+}
+
+// ReplayRequest sends a request built by BuildRequest and returns the
+// parsed response. The access token is refreshed if needed and the
+// authorization header replaced with the current one, so requests can be
+// replayed after the token they were built with expired. Replaying a
+// request that was already delivered sends the message again.
+//
+// No Conversation is updated: the ids to continue the conversation with
+// are in the returned result.
+func (c *ChatGPT) ReplayRequest(req *http.Request) (*ConversationResult, error) {
+	if err := c.RefreshAccessTokenContext(req.Context()); err != nil {
+		return nil, fmt.Errorf("refresh access token: %w", err)
+	}
+	req = req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	} else if req.Body != nil {
+		bs, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(bs))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(bs)), nil
+		}
+	}
+	c.setAuthHeaders(req)
+
+	resp, err := c.do(endpointConversation, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, responseError(resp, body)
+	}
+	return c.NewConversation("", "").readResult(resp, streamHandler{})
+}
@@ -0,0 +1,43 @@
+package chatgpt_go_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestChatGPT_ReplayRequest(t *testing.T) {
+	var tokens []string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{
+		AccessToken:        "stale-token",
+		AccessTokenExpires: time.Now().Add(time.Hour),
+	}, func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("authorization"))
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "next", body["action"])
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	req, err := conversation.BuildRequest("hello")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, conversation.ConversationId)
+
+	// the token expires while the request is queued
+	client.AccessTokenExpires = time.Now().Add(-time.Minute)
+	for i := 0; i < 2; i++ {
+		result, err := client.ReplayRequest(req)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "c1", result.ConversationId)
+			msg, err := result.GetMessage()
+			assert.NoError(t, err)
+			assert.Equal(t, "hi", msg)
+		}
+	}
+	assert.Equal(t, []string{"test-token", "test-token"}, tokens)
+}
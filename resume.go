@@ -0,0 +1,110 @@
+package chatgpt_go
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+type conversationState struct {
+	ConversationId  string `json:"conversation_id"`
+	ParentMessageId string `json:"parent_message_id"`
+	// Model is the served model, RequestedModel the Model set on the
+	// conversation.
+	Model          string `json:"model,omitempty"`
+	RequestedModel string `json:"requested_model,omitempty"`
+	UserAgent      string `json:"user_agent,omitempty"`
+}
+
+// Marshal encodes the conversation ids, models and user agent into an opaque
+// token that can be handed to a client and restored later with
+// ChatGPT.UnmarshalConversation. The token is signed when the client has a
+// ConversationSecret.
+func (c *Conversation) Marshal() ([]byte, error) {
+	bs, err := json.Marshal(conversationState{
+		ConversationId:  c.ConversationId,
+		ParentMessageId: c.ParentMessageId,
+		Model:           c.servedModel,
+		RequestedModel:  c.Model,
+		UserAgent:       c.userAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, base64.RawURLEncoding.EncodedLen(len(bs)))
+	base64.RawURLEncoding.Encode(token, bs)
+	if len(c.ChatGPT.ConversationSecret) > 0 {
+		token = append(token, '.')
+		token = append(token, c.ChatGPT.signConversation(token[:len(token)-1])...)
+	}
+	return token, nil
+}
+
+// UnmarshalConversation restores a conversation from a token created by
+// Conversation.Marshal. ErrInvalidConversationToken is returned when the
+// token is malformed or its signature doesn't match.
+func (c *ChatGPT) UnmarshalConversation(token []byte) (*Conversation, error) {
+	payload := token
+	if len(c.ConversationSecret) > 0 {
+		i := bytes.LastIndexByte(token, '.')
+		if i < 0 {
+			return nil, fmt.Errorf("%w: missing signature", ErrInvalidConversationToken)
+		}
+		payload = token[:i]
+		if !hmac.Equal(token[i+1:], c.signConversation(payload)) {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidConversationToken)
+		}
+	}
+	bs := make([]byte, base64.RawURLEncoding.DecodedLen(len(payload)))
+	n, err := base64.RawURLEncoding.Decode(bs, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConversationToken, err)
+	}
+	state := conversationState{}
+	if err := json.Unmarshal(bs[:n], &state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConversationToken, err)
+	}
+	conversation := c.NewConversation(state.ConversationId, state.ParentMessageId)
+	conversation.servedModel = state.Model
+	conversation.Model = state.RequestedModel
+	if state.UserAgent != "" && c.knownUserAgent(state.UserAgent) {
+		conversation.userAgent = state.UserAgent
+	}
+	return conversation, nil
+}
+
+func (c *ChatGPT) signConversation(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.ConversationSecret)
+	mac.Write(payload)
+	sum := mac.Sum(nil)
+	sig := make([]byte, base64.RawURLEncoding.EncodedLen(len(sum)))
+	base64.RawURLEncoding.Encode(sig, sum)
+	return sig
+}
+
+// ResumeLatest returns the account's most recently updated conversation,
+// ready to send the next message after its latest one.
+// ErrNoConversations is returned when the account has none.
+func (c *ChatGPT) ResumeLatest() (*Conversation, error) {
+	summaries, err := c.ListConversations(0, 1)
+	if err != nil {
+		return nil, fmt.Errorf("list conversations: %w", err)
+	}
+	if len(summaries) == 0 {
+		return nil, ErrNoConversations
+	}
+	id := summaries[0].Id
+	history, err := c.getHistory(context.Background(), id)
+	if err != nil {
+		return nil, fmt.Errorf("get conversation %s: %w", id, err)
+	}
+	leaf := history.leaf()
+	if leaf == "" {
+		return nil, fmt.Errorf("conversation %s has no messages", id)
+	}
+	return c.NewConversation(id, leaf), nil
+}
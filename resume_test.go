@@ -0,0 +1,63 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+)
+
+func TestConversation_Marshal(t *testing.T) {
+	for _, secret := range [][]byte{nil, []byte("secret")} {
+		client, err := chatgpt_go.NewChatGPT(chatgpt_go.ChatGPTOptions{
+			SessionToken:       "session",
+			ClearanceToken:     "clearance",
+			UserAgent:          "test-agent",
+			ConversationSecret: secret,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		token, err := client.NewConversation("c1", "m1").SetModel("gpt-4").Marshal()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		conversation, err := client.UnmarshalConversation(token)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "c1", conversation.ConversationId)
+			assert.Equal(t, "m1", conversation.ParentMessageId)
+			assert.Equal(t, "gpt-4", conversation.Model)
+		}
+
+		_, err = client.UnmarshalConversation(append([]byte("x"), token...))
+		assert.ErrorIs(t, err, chatgpt_go.ErrInvalidConversationToken)
+	}
+}
+
+func TestChatGPT_ResumeLatest(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/backend-api/conversations":
+			assert.Equal(t, "updated", r.URL.Query().Get("order"))
+			_, _ = w.Write([]byte(`{"items":[{"id":"c2","title":"Latest","create_time":"2023-04-01T10:00:00.000000+00:00","update_time":"2023-04-02T10:00:00.000000+00:00"}]}`))
+		case "/backend-api/conversation/c2":
+			_, _ = w.Write([]byte(`{"current_node":"m2","mapping":{
+				"root":{"id":"root","children":["m1"]},
+				"m1":{"id":"m1","parent":"root","children":["m2"],"message":{"id":"m1","author":{"role":"user"},"content":{"parts":["hi"]}}},
+				"m2":{"id":"m2","parent":"m1","message":{"id":"m2","author":{"role":"assistant"},"content":{"parts":["hello"]}}}}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	})
+	conversation, err := client.ResumeLatest()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "c2", conversation.ConversationId)
+		assert.Equal(t, "m2", conversation.ParentMessageId)
+	}
+
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"items":[],"total":0}`))
+	})
+	_, err = client.ResumeLatest()
+	assert.ErrorIs(t, err, chatgpt_go.ErrNoConversations)
+}
@@ -0,0 +1,297 @@
+package chatgpt_go
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryBudget       = 10
+	defaultRetryBudgetRefill = time.Second
+	defaultRetryBackoff      = time.Second
+	maxRetryBackoff          = 30 * time.Second
+	// maxRetryAfter is the longest Retry-After a request waits for before
+	// retrying. A longer one fails the request instead.
+	maxRetryAfter = 2 * time.Minute
+)
+
+// retryBudget is a token bucket shared by every request of a ChatGPT. Each
+// retry takes one token, so a widespread outage can't make all concurrent
+// requests retry at once: once the bucket is empty requests fail fast.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	refill time.Duration
+	last   time.Time
+}
+
+func newRetryBudget(max int, refill time.Duration) *retryBudget {
+	return &retryBudget{tokens: float64(max), max: float64(max), refill: refill, last: time.Now()}
+}
+
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += float64(now.Sub(b.last)) / float64(b.refill)
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// isRetryableStatus reports whether code is retried, either a built-in
+// retryable status or one of RetryableStatuses.
+func (c *ChatGPT) isRetryableStatus(code int) bool {
+	if retryableStatus(code) {
+		return true
+	}
+	for _, status := range c.RetryableStatuses {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns the wait before retrying attempt: retryAfter, the
+// Retry-After the backend sent, when there is one, otherwise RetryBackoff
+// doubled on every attempt, capped at maxRetryBackoff, with equal jitter so
+// concurrent clients don't retry in lockstep. It reports false when
+// retryAfter exceeds maxRetryAfter and the request shouldn't be retried.
+func (c *ChatGPT) retryDelay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= maxRetryAfter
+	}
+	d := c.RetryBackoff
+	if d <= 0 {
+		d = defaultRetryBackoff
+	}
+	for i := 0; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)), true
+}
+
+// retryWait reports whether a failed attempt at url is retried, and after
+// what delay: as long as MaxRetries, the retry budget and the deadline of
+// ctx allow it. retryAfter is the wait the backend asked for, if any.
+func (c *ChatGPT) retryWait(ctx context.Context, url string, attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt >= c.MaxRetries {
+		return 0, false
+	}
+	delay, ok := c.retryDelay(attempt, retryAfter)
+	if !ok {
+		// the backend asked for a longer wait than is worth blocking on
+		return 0, false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		// the retry couldn't complete in time, report this failure instead
+		return 0, false
+	}
+	if c.retryBudget != nil && !c.retryBudget.take() {
+		c.debug("retry budget exhausted", map[string]interface{}{"url": url})
+		return 0, false
+	}
+	return delay, true
+}
+
+// sleepRetry records the retry of attempt, which failed with err, and waits
+// delay before it, or until ctx is done.
+func (c *ChatGPT) sleepRetry(ctx context.Context, endpoint string, url string, attempt int, delay time.Duration, err error) error {
+	c.debug("retry "+url, map[string]interface{}{"error": err, "attempt": attempt + 1, "delay": delay})
+	if c.Metrics != nil {
+		c.Metrics.IncRetry(endpoint)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// sendAttemptKey is the context key of the *sendAttempt of a send retried
+// by retrySends, whose request doRetry then doesn't retry on its own.
+type sendAttemptKey struct{}
+
+// sendAttempt is an attempt of retrySends. streamed is set once the
+// response was accepted and its stream is read: failing later, the reply
+// may already have been delivered in part, so the send isn't retried.
+type sendAttempt struct {
+	streamed bool
+}
+
+// retryableSend reports whether a send failing with err before its
+// response streamed is retried: for the retryable statuses, including
+// RetryableStatuses, and the errors IsRetryable accepts, timeouts of the
+// attempt included. ctx is the context of the send.
+func (c *ChatGPT) retryableSend(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrMessageCapReached) || errors.Is(err, ErrCloudflareChallenge) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && c.isRetryableStatus(statusErr.StatusCode) {
+		return true
+	}
+	return IsRetryable(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// do sends req, retrying transient failures up to MaxRetries times with
+// exponential backoff as long as the retry budget and the context deadline
+// allow it, except for the requests of sends, which retrySends retries. The
+// circuit breaker, when enabled, sees the outcome of the request once
+// retries are over. The RequestInterceptor sees the request before all that.
+func (c *ChatGPT) do(endpoint string, req *http.Request) (*http.Response, error) {
+	if c.RequestInterceptor != nil {
+		if err := c.RequestInterceptor(req); err != nil {
+			return nil, fmt.Errorf("request interceptor: %w", err)
+		}
+	}
+	if c.breaker == nil {
+		return c.doRetry(endpoint, req)
+	}
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.doRetry(endpoint, req)
+	switch {
+	case req.Context().Err() != nil:
+		c.breaker.release()
+	case err != nil:
+		c.breaker.record(!IsRetryable(err))
+	default:
+		c.breaker.record(!c.isRetryableStatus(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// httpClient returns the HTTPClient, or the client shared by all requests,
+// built on first use so that connections are kept alive across requests.
+func (c *ChatGPT) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	c.clientOnce.Do(func() {
+		c.client = &http.Client{Transport: c.transport}
+	})
+	return c.client
+}
+
+// doAttempt sends req once. Timeout bounds the attempt, reading the response
+// body included, through the request context rather than the shared client.
+func (c *ChatGPT) doAttempt(req *http.Request) (*http.Response, error) {
+	if c.HTTPClient != nil || c.Timeout <= 0 {
+		return c.httpClient().Do(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), c.Timeout)
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of the request when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (c *ChatGPT) doRetry(endpoint string, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if c.RateLimiter != nil {
+			if err := c.RateLimiter.Wait(req.Context()); err != nil {
+				return nil, fmt.Errorf("rate limiter: %w", err)
+			}
+		}
+		start := time.Now()
+		resp, err := c.doAttempt(req)
+		if c.Metrics != nil {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			c.Metrics.ObserveRequest(endpoint, status, time.Since(start))
+		}
+		retry := false
+		if err != nil {
+			// a deadline exceeded while the request's context isn't is the
+			// Timeout of this attempt, which the next one may beat
+			retry = req.Context().Err() == nil && (IsRetryable(err) || errors.Is(err, context.DeadlineExceeded))
+		} else {
+			// a challenge won't be solved by retrying
+			retry = c.isRetryableStatus(resp.StatusCode) && !isCloudflareChallenge(resp)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				// a message cap won't clear by retrying
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+				_ = resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewReader(body))
+				retry = !isMessageCap(body)
+			}
+		}
+		if !retry || req.Context().Value(sendAttemptKey{}) != nil || (req.Body != nil && req.GetBody == nil) {
+			// sends are retried as a whole by retrySends
+			return resp, err
+		}
+		var retryAfter time.Duration
+		if resp != nil {
+			retryAfter = parseRetryAfter(resp.Header.Get("retry-after"), time.Now())
+		}
+		delay, ok := c.retryWait(req.Context(), req.URL.String(), attempt, retryAfter)
+		if !ok {
+			return resp, err
+		}
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		if err := c.sleepRetry(req.Context(), endpoint, req.URL.String(), attempt, delay, err); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package chatgpt_go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(2, time.Hour)
+	assert.True(t, b.take())
+	assert.True(t, b.take())
+	assert.False(t, b.take())
+
+	b.last = b.last.Add(-time.Hour)
+	assert.True(t, b.take())
+	assert.False(t, b.take())
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limited", newStatusError(429, []byte(`{"detail":"Too many requests"}`)), true},
+		{"overloaded", newStatusError(503, []byte(`{"detail":"That model is currently overloaded with other requests."}`)), true},
+		{"bad gateway", newStatusError(502, nil), true},
+		{"unauthorized", newStatusError(401, nil), false},
+		{"forbidden", newStatusError(403, nil), false},
+		{"invalid model", newStatusError(400, []byte(`{"detail":"invalid model"}`)), false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"wrapped", fmt.Errorf("send: %w", newStatusError(500, nil)), true},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"wrapped deadline exceeded", fmt.Errorf("send: %w", context.DeadlineExceeded), false},
+		{"message cap", newStatusError(429, []byte(`{"detail":{"code":"model_cap_exceeded","clears_in":60}}`)), false},
+		{"cloudflare challenge", &StatusError{StatusCode: 503, err: ErrCloudflareChallenge}, false},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+	assert.ErrorIs(t, newStatusError(429, nil), ErrRateLimited)
+	assert.ErrorIs(t, newStatusError(400, []byte(`{"detail":"Parent message not found"}`)), ErrInvalidParent)
+}
+
+func TestStatusError_Error(t *testing.T) {
+	err := newStatusError(502, []byte(`<html>`+strings.Repeat("é", maxErrorBody)+`</html>`))
+	message := err.Error()
+	assert.True(t, strings.HasPrefix(message, "response status code=502, body=<html>é"))
+	assert.True(t, strings.HasSuffix(message, "é..."))
+	assert.LessOrEqual(t, len(message), len("response status code=502, body=")+maxErrorBody+len("..."))
+	assert.Len(t, err.Body, len(`<html></html>`)+2*maxErrorBody)
+}
+
+func TestRetryDelay(t *testing.T) {
+	c := &ChatGPT{RetryBackoff: 100 * time.Millisecond}
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		d, ok := c.retryDelay(attempt, 0)
+		assert.True(t, ok)
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+	d, _ := c.retryDelay(20, 0)
+	assert.LessOrEqual(t, d, maxRetryBackoff)
+	assert.GreaterOrEqual(t, d, maxRetryBackoff/2)
+
+	d, ok := c.retryDelay(0, 7*time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+
+	_, ok = c.retryDelay(0, time.Hour)
+	assert.False(t, ok)
+}
@@ -0,0 +1,374 @@
+package chatgpt_go
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// streamText sends body and calls onDelta with every piece of text added to
+// the reply, after PlainText and StreamTransformer are applied. Returning
+// false from onDelta stops the generation. It returns the final result and
+// the reply text: the message, as SendMessage returns it, or the output of
+// the StreamTransformer when there is one.
+func (c *Conversation) streamText(ctx context.Context, body *ConversationBody, onDelta func(delta string) bool) (*ConversationResult, string, error) {
+	ts := c.ChatGPT.newTextStream()
+	result, err := c.send(ctx, body, streamHandler{onResult: func(r *ConversationResult) bool {
+		delta := ts.update(r.text(), false)
+		if delta == "" || onDelta == nil {
+			return true
+		}
+		return onDelta(delta)
+	}})
+	if err != nil {
+		return result, ts.String(), err
+	}
+	text, err := result.message()
+	if err != nil {
+		return result, ts.String(), err
+	}
+	if delta := ts.update(result.text(), true); delta != "" && onDelta != nil {
+		onDelta(delta)
+	}
+	if c.ChatGPT.StreamTransformer != nil {
+		text = ts.String()
+	}
+	return result, text, nil
+}
+
+// SendMessageStream sends message and calls onDelta with every piece of
+// text added to the reply as it streams in, e.g. to render it in a UI, and
+// returns the whole reply once it is complete, as SendMessage does. Events
+// that fail to parse are skipped.
+func (c *Conversation) SendMessageStream(message string, onDelta func(delta string)) (string, error) {
+	_, text, err := c.streamText(context.Background(), c.nextBody(message), func(delta string) bool {
+		onDelta(delta)
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// SendOptions are per-message request fields for SendMessageWithOptions.
+// The zero value sends the message like SendMessage.
+type SendOptions struct {
+	// TimezoneOffsetMin overrides the client's TimezoneOffset.
+	TimezoneOffsetMin *int
+	// HistoryDisabled keeps the conversation out of the account's history
+	// and of model training.
+	HistoryDisabled bool
+	// PluginIds are the plugins the model may use, for plugin models.
+	PluginIds []string
+}
+
+// SendMessageWithOptions sends message like SendMessage, with the request
+// fields set by options.
+func (c *Conversation) SendMessageWithOptions(message string, options SendOptions) (string, error) {
+	body := c.nextBody(message)
+	body.TimezoneOffset = options.TimezoneOffsetMin
+	body.HistoryAndTrainingDisabled = options.HistoryDisabled
+	body.PluginIds = options.PluginIds
+	return c.sendMessage(context.Background(), body)
+}
+
+// SendMessagePreview sends message and stops the generation as soon as
+// maxChars characters have been received, returning at most maxChars
+// characters of the reply. The request is cancelled early to save tokens.
+// A negative maxChars is an error.
+func (c *Conversation) SendMessagePreview(message string, maxChars int) (string, error) {
+	if maxChars < 0 {
+		return "", fmt.Errorf("invalid preview length %d", maxChars)
+	}
+	result, err := c.send(context.Background(), c.nextBody(message), streamHandler{onResult: func(r *ConversationResult) bool {
+		return len([]rune(r.text())) < maxChars
+	}})
+	if err != nil {
+		return "", err
+	}
+	text, err := result.message()
+	if err != nil {
+		return "", err
+	}
+	if runes := []rune(text); len(runes) > maxChars {
+		text = string(runes[:maxChars])
+	}
+	return text, nil
+}
+
+// SendMessageAs sends message with name as the author name, for setups where
+// several agents talk in one conversation. Not every backend supports
+// author names: chat.openai.com is known to ignore them, in which case the
+// message is sent as a regular user message.
+func (c *Conversation) SendMessageAs(name string, message string) (string, error) {
+	body := c.nextBody(message)
+	body.Messages[0].Author = &MessageAuthor{Role: "user", Name: name}
+	return c.sendMessage(context.Background(), body)
+}
+
+// SendMessageWithin sends message and streams the reply for at most d. When
+// the reply isn't complete by then the request is cancelled and the text
+// received so far is returned with truncated set; the conversation isn't
+// advanced in that case, as with a closed SendMessageReader. When no text
+// was received at all, context.DeadlineExceeded is returned instead.
+func (c *Conversation) SendMessageWithin(message string, d time.Duration) (text string, truncated bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	ts := c.ChatGPT.newTextStream()
+	partial := ""
+	result, err := c.send(ctx, c.nextBody(message), streamHandler{onResult: func(r *ConversationResult) bool {
+		partial = r.text()
+		ts.update(partial, false)
+		return true
+	}})
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			if partial == "" {
+				return "", false, ctx.Err()
+			}
+			ts.update(partial, true)
+			return ts.String(), true, nil
+		}
+		return "", false, err
+	}
+	if _, err := result.message(); err != nil {
+		return "", false, err
+	}
+	ts.update(result.text(), true)
+	return ts.String(), false, nil
+}
+
+const defaultMaxRateLimitWait = 5 * time.Minute
+
+// SendMessageWaitRateLimit is SendMessageContext waiting out rate limits:
+// when the send is rate limited, or the message cap reached, and the
+// backend told when it is lifted, it sleeps until then and sends the
+// message again, once. Limits lifted later than MaxRateLimitWait, or with
+// an unknown reset, are returned as is.
+func (c *Conversation) SendMessageWaitRateLimit(ctx context.Context, message string) (string, error) {
+	text, err := c.SendMessageContext(ctx, message)
+	reset, ok := rateLimitReset(err)
+	if !ok {
+		return text, err
+	}
+	maxWait := c.ChatGPT.MaxRateLimitWait
+	if maxWait <= 0 {
+		maxWait = defaultMaxRateLimitWait
+	}
+	wait := time.Until(reset)
+	if wait > maxWait {
+		return "", err
+	}
+	c.ChatGPT.debug("wait rate limit", map[string]interface{}{"error": err, "wait": wait})
+	timer := time.NewTimer(wait)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return "", ctx.Err()
+	case <-timer.C:
+	}
+	return c.SendMessageContext(ctx, message)
+}
+
+// ContinueGeneration asks the backend to continue the last response of the
+// conversation, e.g. after it was cut at the length limit, and returns the
+// response text appended to the output received so far on that turn.
+// ErrActionNotSupported is returned when the conversation's
+// model is known not to support continuing.
+func (c *Conversation) ContinueGeneration() (string, error) {
+	if c.ConversationId == "" {
+		return "", fmt.Errorf("no response to continue")
+	}
+	model := c.model()
+	if !c.ChatGPT.modelSupports(model, "continue") {
+		return "", fmt.Errorf("%w: %s can't continue", ErrActionNotSupported, model)
+	}
+	prior := c.lastOutput
+	result, err := c.send(context.Background(), &ConversationBody{Action: "continue"}, streamHandler{})
+	if err != nil {
+		return "", err
+	}
+	more, err := result.message()
+	if err != nil {
+		return "", err
+	}
+	return prior + more, nil
+}
+
+// Regenerate asks the backend for another reply to the last message sent
+// on the conversation, as the web UI's regenerate button does, and returns
+// it. The conversation then goes on from the new reply.
+// ErrActionNotSupported is returned when the conversation's model is known
+// not to support variants.
+func (c *Conversation) Regenerate() (string, error) {
+	if c.lastPrompt == nil {
+		return "", fmt.Errorf("no message to regenerate")
+	}
+	model := c.model()
+	if !c.ChatGPT.modelSupports(model, "variant") {
+		return "", fmt.Errorf("%w: %s can't regenerate", ErrActionNotSupported, model)
+	}
+	return c.sendMessage(context.Background(), &ConversationBody{
+		Action:          "variant",
+		Messages:        []ConversationBodyMessage{*c.lastPrompt},
+		ParentMessageId: c.lastPromptParent,
+	})
+}
+
+// maxContinuations caps how many times SendComplete continues a reply.
+const maxContinuations = 5
+
+// SendComplete sends message and, while the reply is cut at the length
+// limit, continues it up to 5 times, returning the pieces joined into one
+// answer. When a continuation fails the text received so far is returned
+// with the error.
+func (c *Conversation) SendComplete(message string) (string, error) {
+	ctx := context.Background()
+	result, err := c.send(ctx, c.nextBody(message), streamHandler{})
+	if err != nil {
+		return "", err
+	}
+	text, err := result.message()
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < maxContinuations && result.Truncated(); i++ {
+		if !c.ChatGPT.modelSupports(c.model(), "continue") {
+			break
+		}
+		result, err = c.send(ctx, &ConversationBody{Action: "continue"}, streamHandler{})
+		if err != nil {
+			return text, fmt.Errorf("continue: %w", err)
+		}
+		more, err := result.message()
+		if err != nil {
+			return text, fmt.Errorf("continue: %w", err)
+		}
+		text += more
+	}
+	return text, nil
+}
+
+// SendMessageReader sends message and returns a reader yielding the reply
+// as it streams in, e.g. to io.Copy it to os.Stdout. Errors happening while
+// sending are returned by Read.
+//
+// Closing the reader cancels the request, which stops the generation. When
+// it is closed before the reply is complete the conversation isn't
+// advanced: the next message is sent after the same parent as this one.
+func (c *Conversation) SendMessageReader(message string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	r := &streamReader{PipeReader: pr, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		_, _, err := c.streamText(ctx, c.nextBody(message), func(delta string) bool {
+			_, err := pw.Write([]byte(delta))
+			return err == nil
+		})
+		_ = pw.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+const defaultStreamBufferSize = 16
+
+// StreamDelta is a piece of a reply sent by StreamChannel. The last value
+// has Err set when the reply failed.
+type StreamDelta struct {
+	Text string
+	Err  error
+}
+
+// StreamChannel sends message and returns a channel yielding the reply as
+// it streams in. The channel is closed once the reply is complete or
+// failed.
+//
+// The channel holds StreamBufferSize deltas. When the consumer falls behind
+// and the buffer is full, reading the response is paused until it catches
+// up: nothing is dropped. If ctx is done meanwhile the request is
+// cancelled, which stops the generation, and the channel is closed; the
+// final error is only sent when the buffer has room for it, so check
+// ctx.Err() when the channel is closed without one. Consumers giving up
+// on the reply must cancel ctx, or the request stays paused.
+func (c *Conversation) StreamChannel(ctx context.Context, message string) <-chan StreamDelta {
+	size := c.ChatGPT.StreamBufferSize
+	if size <= 0 {
+		size = defaultStreamBufferSize
+	}
+	ch := make(chan StreamDelta, size)
+	body := c.nextBody(message)
+	go func() {
+		defer close(ch)
+		_, _, err := c.streamText(ctx, body, func(delta string) bool {
+			select {
+			case ch <- StreamDelta{Text: delta}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if err == nil {
+			return
+		}
+		select {
+		case ch <- StreamDelta{Err: err}:
+		default:
+			select {
+			case ch <- StreamDelta{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
+
+type streamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (r *streamReader) Close() error {
+	r.cancel()
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
+
+// SendMessageFrames sends message and calls onFrame with the raw JSON payload
+// of every event of the response stream, leaving their interpretation to
+// the caller. Returning false from onFrame stops the stream early. [DONE]
+// and keep-alive events are handled by the package and not passed on, and
+// so are consecutive duplicate events when DedupeFrames is set.
+//
+// The events don't need to match ConversationResult: the conversation is
+// advanced when one does, and left as is otherwise.
+func (c *Conversation) SendMessageFrames(message string, onFrame func(raw []byte) bool) error {
+	var (
+		stopped   bool
+		delivered bool
+		prev      []byte
+	)
+	_, err := c.send(context.Background(), c.nextBody(message), streamHandler{onFrame: func(raw []byte) bool {
+		if c.ChatGPT.DedupeFrames {
+			if bytes.Equal(raw, prev) {
+				return true
+			}
+			prev = raw
+		}
+		delivered = true
+		stopped = !onFrame(raw)
+		return !stopped
+	}})
+	if delivered && errors.Is(err, ErrEmptyResponse) {
+		// no event the package understands
+		return nil
+	}
+	return err
+}
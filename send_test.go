@@ -0,0 +1,594 @@
+package chatgpt_go_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowStream streams a reply one word at a time until the client goes away.
+func slowStream(t *testing.T, cancelled chan<- struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		text := ""
+		for i := 0; i < 200; i++ {
+			text += "word "
+			if _, err := fmt.Fprintf(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[%q]}},\"conversation_id\":\"c1\"}\n\n", text); err != nil {
+				break
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				close(cancelled)
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+		t.Error("generation was not cancelled")
+	}
+}
+
+func TestConversation_SendMessageContext_Cancel(t *testing.T) {
+	cancelled := make(chan struct{})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, slowStream(t, cancelled))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	conversation := client.NewConversation("", "m0")
+	_, err := conversation.SendMessageContext(ctx, "hi")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+}
+
+func TestConversation_SendMessageFull(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","role":"assistant","create_time":1678000000,"content":{"parts":["hi"]},"metadata":{"model_slug":"gpt-4"}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	result, err := conversation.SendMessageFull("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "m1", result.Message.Id)
+		assert.Equal(t, "assistant", result.Message.Role)
+		assert.Equal(t, "gpt-4", result.Message.Metadata.ModelSlug)
+		assert.Equal(t, int64(1678000000), result.CreateTime().Unix())
+		msg, err := result.GetMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", msg)
+	}
+	assert.Equal(t, "c1", conversation.ConversationId)
+	assert.Equal(t, "m1", conversation.ParentMessageId)
+}
+
+func TestConversation_SendMessageFull_Metadata(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["a long"]},"metadata":{"model_slug":"gpt-4","message_type":"next","is_complete":true,"finish_details":{"type":"max_tokens"},"usage":{"prompt_tokens":5,"completion_tokens":512,"total_tokens":517}}},"conversation_id":"c1"}`)
+	})
+	result, err := client.NewConversation("", "").SendMessageFull("hello")
+	if assert.NoError(t, err) {
+		metadata := result.Message.Metadata
+		assert.Equal(t, "next", metadata.MessageType)
+		assert.True(t, metadata.IsComplete)
+		assert.True(t, result.Truncated())
+		assert.Equal(t, &chatgpt_go.TokenUsage{PromptTokens: 5, CompletionTokens: 512, TotalTokens: 517}, metadata.Usage)
+	}
+}
+
+func TestConversation_SendMessageStream(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Hell`,
+			`{"message":{"id":"m1","content":{"parts":["Hello, wor"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Hello, world"]}},"conversation_id":"c1"}`,
+		)
+	})
+	var deltas []string
+	text, err := client.NewConversation("", "").SendMessageStream("hi", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hello, world", text)
+		assert.Equal(t, []string{"Hel", "lo, wor", "ld"}, deltas)
+	}
+}
+
+func TestConversation_SendMessage_RewrittenStream(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["h"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Hi"]}},"conversation_id":"c1"}`,
+		)
+	}
+	identity := func(delta string) string { return delta }
+	for _, options := range []chatgpt_go.ChatGPTOptions{{}, {StreamTransformer: identity}} {
+		text, err := newTestClient(t, options, handler).NewConversation("", "").SendMessage("hello")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "Hi", text)
+		}
+	}
+}
+
+func TestConversation_SendMessageStream_Rewritten(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["h"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Hi"]}},"conversation_id":"c1"}`,
+		)
+	})
+	sent, err := client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+	var deltas []string
+	streamed, err := client.NewConversation("", "").SendMessageStream("hello", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Hi", streamed)
+		assert.Equal(t, sent, streamed)
+		assert.Equal(t, []string{"h", "i", "Hi"}, deltas)
+	}
+}
+
+func TestConversation_SendMessageReader(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`,
+		)
+	})
+	r, err := client.NewConversation("", "").SendMessageReader("hi")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	buf := &bytes.Buffer{}
+	_, err = io.Copy(buf, r)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", buf.String())
+	assert.NoError(t, r.Close())
+}
+
+func TestConversation_SendMessageReader_Close(t *testing.T) {
+	cancelled := make(chan struct{})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, slowStream(t, cancelled))
+	// open the keep-alive connection used for the session before counting
+	assert.NoError(t, client.RefreshAccessToken())
+	goroutines := runtime.NumGoroutine()
+
+	conversation := client.NewConversation("", "m0")
+	r, err := conversation.SendMessageReader("hi")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	buf := make([]byte, 8)
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= goroutines
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConversation_StreamChannel(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w,
+			`{"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`,
+			`{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`,
+		)
+	})
+	text := ""
+	for delta := range client.NewConversation("", "").StreamChannel(context.Background(), "hi") {
+		assert.NoError(t, delta.Err)
+		text += delta.Text
+	}
+	assert.Equal(t, "Hello", text)
+}
+
+func TestConversation_StreamChannel_SlowConsumer(t *testing.T) {
+	cancelled := make(chan struct{})
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{StreamBufferSize: 1}, slowStream(t, cancelled))
+	assert.NoError(t, client.RefreshAccessToken())
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	conversation := client.NewConversation("", "m0")
+	ch := conversation.StreamChannel(ctx, "hi")
+
+	// the consumer doesn't read until the deadline
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	deltas := 0
+	for delta := range ch {
+		if delta.Err != nil {
+			assert.ErrorIs(t, delta.Err, context.DeadlineExceeded)
+			continue
+		}
+		deltas++
+	}
+	assert.LessOrEqual(t, deltas, 2)
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= goroutines
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConversation_SendMessageFrames(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		_, _ = fmt.Fprint(w, ": ping\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hel\"]}},\"conversation_id\":\"c1\"}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"v\":2,\"unknown\":true}\n\n")
+		_, _ = fmt.Fprint(w, "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hello\"]}},\"conversation_id\":\"c1\"}\n\n")
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	conversation := client.NewConversation("", "")
+	var frames []string
+	err := conversation.SendMessageFrames("hi", func(raw []byte) bool {
+		frames = append(frames, string(raw))
+		return true
+	})
+	if assert.NoError(t, err) {
+		assert.Len(t, frames, 3)
+		assert.JSONEq(t, `{"v":2,"unknown":true}`, frames[1])
+		assert.Equal(t, "m1", conversation.ParentMessageId)
+	}
+
+	frames = nil
+	err = conversation.SendMessageFrames("hi", func(raw []byte) bool {
+		frames = append(frames, string(raw))
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Len(t, frames, 1)
+}
+
+func TestConversation_SendMessageFrames_UnknownSchema(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{StrictJSON: true}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":"Hel","v":2}`, `{"message":"Hello","v":2}`)
+	})
+	conversation := client.NewConversation("", "m0")
+	var frames []string
+	err := conversation.SendMessageFrames("hi", func(raw []byte) bool {
+		frames = append(frames, string(raw))
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Len(t, frames, 2)
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+	assert.Empty(t, conversation.ConversationId)
+}
+
+func TestConversation_DuplicateFrames(t *testing.T) {
+	frames := []string{
+		`{"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`,
+		`{"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`,
+		`{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`,
+		`{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`,
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, frames...)
+	}
+
+	r, err := newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler).NewConversation("", "").SendMessageReader("hi")
+	if assert.NoError(t, err) {
+		text, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello", string(text))
+	}
+
+	for _, dedupe := range []bool{false, true} {
+		client := newTestClient(t, chatgpt_go.ChatGPTOptions{DedupeFrames: dedupe}, handler)
+		count := 0
+		err := client.NewConversation("", "").SendMessageFrames("hi", func(raw []byte) bool {
+			count++
+			return true
+		})
+		assert.NoError(t, err)
+		if dedupe {
+			assert.Equal(t, 2, count)
+		} else {
+			assert.Equal(t, 4, count)
+		}
+	}
+}
+
+func TestConversation_SendMessageAs(t *testing.T) {
+	var messages []interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		messages = append(messages, body["messages"].([]interface{})[0])
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	_, err := conversation.SendMessageAs("planner", "hello")
+	assert.NoError(t, err)
+	_, err = conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, map[string]interface{}{"role": "user", "name": "planner"}, messages[0].(map[string]interface{})["author"])
+		assert.NotContains(t, messages[1], "author")
+	}
+}
+
+func TestConversation_SendComplete(t *testing.T) {
+	var actions []interface{}
+	var finish func(calls int) string
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backend-api/conversation" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		actions = append(actions, body["action"])
+		writeStream(w, fmt.Sprintf(`{"message":{"id":"m%d","content":{"parts":["%d "]},"metadata":{"finish_details":{"type":"%s"}}},"conversation_id":"c1"}`,
+			len(actions), len(actions), finish(len(actions))))
+	})
+
+	finish = func(calls int) string {
+		if calls < 3 {
+			return "max_tokens"
+		}
+		return "stop"
+	}
+	resp, err := client.NewConversation("", "").SendComplete("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1 2 3 ", resp)
+		assert.Equal(t, []interface{}{"next", "continue", "continue"}, actions)
+	}
+
+	actions = nil
+	finish = func(calls int) string { return "max_tokens" }
+	resp, err = client.NewConversation("", "").SendComplete("hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1 2 3 4 5 6 ", resp)
+		assert.Len(t, actions, 6)
+	}
+}
+
+func TestChatGPT_PromptTransformer_Redactor(t *testing.T) {
+	var prompts []interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{
+		PromptTransformer: func(prompt string) string { return "Answer briefly. " + prompt },
+		Redactor:          chatgpt_go.RedactPII,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		message := body["messages"].([]interface{})[0].(map[string]interface{})
+		prompts = append(prompts, message["content"].(map[string]interface{})["parts"].([]interface{})[0])
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	_, err := conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	_, err = conversation.SendMessageAs("planner", "hi jane@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"Answer briefly. hello", "Answer briefly. hi [EMAIL]"}, prompts)
+}
+
+func TestConversation_SendMessageWithin(t *testing.T) {
+	cancelled := make(chan struct{})
+	conversation := newTestClient(t, chatgpt_go.ChatGPTOptions{}, slowStream(t, cancelled)).NewConversation("", "m0")
+	text, truncated, err := conversation.SendMessageWithin("hi", 100*time.Millisecond)
+	if assert.NoError(t, err) {
+		assert.True(t, truncated)
+		assert.True(t, strings.HasPrefix(text, "word word "), text)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`)
+	})
+	text, truncated, err = client.NewConversation("", "").SendMessageWithin("hi", time.Minute)
+	if assert.NoError(t, err) {
+		assert.False(t, truncated)
+		assert.Equal(t, "Hello", text)
+	}
+	// a reply that never started is a timeout, not an empty reply
+	release := make(chan struct{})
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	conversation = client.NewConversation("", "m0")
+	text, truncated, err = conversation.SendMessageWithin("hi", 50*time.Millisecond)
+	close(release)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, truncated)
+	assert.Empty(t, text)
+	assert.Equal(t, "m0", conversation.ParentMessageId)
+}
+
+func TestConversation_SendMessageWaitRateLimit(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("retry-after", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler)
+	start := time.Now()
+	resp, err := client.NewConversation("", "").SendMessageWaitRateLimit(context.Background(), "hello")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hi", resp)
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	}
+
+	calls = 0
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{MaxRateLimitWait: time.Millisecond}, handler)
+	_, err = client.NewConversation("", "").SendMessageWaitRateLimit(context.Background(), "hello")
+	assert.ErrorIs(t, err, chatgpt_go.ErrRateLimited)
+	var statusErr *chatgpt_go.StatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, time.Second, statusErr.RetryAfter)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestConversation_Regenerate(t *testing.T) {
+	var bodies []chatgpt_go.ConversationBody
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/backend-api/conversation" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := chatgpt_go.ConversationBody{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		writeStream(w, fmt.Sprintf(`{"message":{"id":"a%d","content":{"parts":["answer %d"]}},"conversation_id":"c1"}`, len(bodies), len(bodies)))
+	})
+	conversation := client.NewConversation("c1", "m0")
+	_, err := conversation.Regenerate()
+	assert.Error(t, err)
+
+	_, err = conversation.SendMessage("hello")
+	assert.NoError(t, err)
+	message, err := conversation.Regenerate()
+	assert.NoError(t, err)
+	assert.Equal(t, "answer 2", message)
+	assert.Equal(t, "a2", conversation.ParentMessageId)
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, "variant", bodies[1].Action)
+		assert.Equal(t, "m0", bodies[1].ParentMessageId)
+		assert.Equal(t, bodies[0].Messages, bodies[1].Messages)
+	}
+}
+
+func TestConversation_SendJSON(t *testing.T) {
+	var prompts []string
+	replies := []string{"Sure:\n```json\n{\"name\": \"Ada\"\n```", "```json\n{\"name\": \"Ada\"}\n```"}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body := chatgpt_go.ConversationBody{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		prompts = append(prompts, body.Messages[0].Content.Parts[0])
+		reply := replies[(len(prompts)-1)%len(replies)]
+		writeStream(w, fmt.Sprintf(`{"message":{"id":"m%d","content":{"parts":[%q]}},"conversation_id":"c1"}`, len(prompts), reply))
+	}
+
+	// no fix-up message is posted by default
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, handler)
+	_, err := client.NewConversation("", "").SendJSON("Who wrote the first program?", `{"name": string}`)
+	assert.ErrorIs(t, err, chatgpt_go.ErrInvalidJSON)
+	assert.Len(t, prompts, 1)
+
+	prompts = nil
+	client = newTestClient(t, chatgpt_go.ChatGPTOptions{FixInvalidJSON: true}, handler)
+	raw, err := client.NewConversation("", "").SendJSON("Who wrote the first program?", `{"name": string}`)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"name":"Ada"}`, string(raw))
+	}
+	if assert.Len(t, prompts, 2) {
+		assert.Contains(t, prompts[0], "Who wrote the first program?")
+		assert.Contains(t, prompts[0], `{"name": string}`)
+		assert.Contains(t, prompts[1], "not valid JSON")
+	}
+
+	prompts, replies = nil, []string{"I don't know."}
+	_, err = client.NewConversation("", "").SendJSON("Who?", "")
+	assert.ErrorIs(t, err, chatgpt_go.ErrInvalidJSON)
+	assert.Len(t, prompts, 2)
+}
+
+func TestConversation_SendMessageWithOptions(t *testing.T) {
+	var bodies []map[string]interface{}
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hi"]}},"conversation_id":"c1"}`)
+	})
+	offset := 120
+	_, err := client.NewConversation("", "").SendMessageWithOptions("hello", chatgpt_go.SendOptions{
+		TimezoneOffsetMin: &offset,
+		HistoryDisabled:   true,
+		PluginIds:         []string{"plugin-1", "plugin-2"},
+	})
+	assert.NoError(t, err)
+	_, err = client.NewConversation("", "").SendMessageWithOptions("hello", chatgpt_go.SendOptions{})
+	assert.NoError(t, err)
+	_, err = client.NewConversation("", "").SendMessage("hello")
+	assert.NoError(t, err)
+
+	if assert.Len(t, bodies, 3) {
+		assert.Equal(t, float64(120), bodies[0]["timezone_offset_min"])
+		assert.Equal(t, true, bodies[0]["history_and_training_disabled"])
+		assert.Equal(t, []interface{}{"plugin-1", "plugin-2"}, bodies[0]["plugin_ids"])
+		for _, body := range bodies[1:] {
+			assert.NotContains(t, body, "timezone_offset_min")
+			assert.NotContains(t, body, "history_and_training_disabled")
+			assert.NotContains(t, body, "plugin_ids")
+		}
+		delete(bodies[1]["messages"].([]interface{})[0].(map[string]interface{}), "id")
+		delete(bodies[2]["messages"].([]interface{})[0].(map[string]interface{}), "id")
+		delete(bodies[1], "parent_message_id")
+		delete(bodies[2], "parent_message_id")
+		assert.Equal(t, bodies[2], bodies[1])
+	}
+}
+
+func TestChatGPT_OnConversationID(t *testing.T) {
+	notified := make(chan string, 10)
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{OnConversationID: func(conversation *chatgpt_go.Conversation, conversationId string) {
+		notified <- conversationId
+	}}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		_, _ = fmt.Fprint(w, `data: {"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}`+"\n\n")
+		w.(http.Flusher).Flush()
+		// the id is reported while the reply is still being generated
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(5 * time.Second):
+			t.Error("conversation id not reported before the end of the stream")
+		case id := <-notified:
+			notified <- id
+		}
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	_, err := conversation.SendMessage("hi")
+	assert.NoError(t, err)
+	close(notified)
+	var ids []string
+	for id := range notified {
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []string{"c1"}, ids)
+}
@@ -0,0 +1,196 @@
+package chatgpt_go
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var dataField = []byte("data:")
+
+// errStopStream is returned from a stream callback to stop reading early.
+var errStopStream = errors.New("stop stream")
+
+// streamHandler receives the events read from a conversation stream: the
+// raw payloads first, then the ones that parse as a ConversationResult.
+// Returning false from a callback stops reading and cancels the request.
+type streamHandler struct {
+	onFrame  func(raw []byte) bool
+	onResult func(r *ConversationResult) bool
+}
+
+// readEventStream reads the event stream from r and calls fn with the
+// payload of every event until the stream ends or a [DONE] payload is read.
+//
+// Events are normally separated by a blank line, but some backends send
+// data lines back to back. A data line is therefore treated as a complete
+// event on its own when the data buffered before it already is a complete
+// payload, and joined to it as a multi-line payload otherwise.
+//
+// ctx is checked between lines so a cancellation stops reading even when
+// the reader itself doesn't observe it.
+func readEventStream(ctx context.Context, r io.Reader, fn func(data []byte) error) error {
+	br := bufio.NewReader(r)
+	var data []byte
+	pending := false
+
+	dispatch := func() (bool, error) {
+		if !pending {
+			return false, nil
+		}
+		value := data
+		data, pending = nil, false
+		if string(value) == "[DONE]" {
+			return true, nil
+		}
+		return false, fn(value)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := readLine(br)
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0:
+			if done, err := dispatch(); done || err != nil {
+				return err
+			}
+		case bytes.HasPrefix(line, dataField):
+			value := bytes.TrimPrefix(line[len(dataField):], []byte{' '})
+			if pending && completePayload(data) {
+				if done, err := dispatch(); done || err != nil {
+					return err
+				}
+			}
+			if pending {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+			pending = true
+		}
+
+		if err == io.EOF {
+			_, err := dispatch()
+			return err
+		}
+	}
+}
+
+// readLine reads the next line from br. The returned slice is only valid
+// until the next read, so lines that are skipped (pings, comments, other
+// fields) cost no allocation; only lines longer than the buffer are copied.
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	long := append([]byte(nil), line...)
+	for err == bufio.ErrBufferFull {
+		line, err = br.ReadSlice('\n')
+		long = append(long, line...)
+	}
+	return long, err
+}
+
+func completePayload(data []byte) bool {
+	return string(data) == "[DONE]" || json.Valid(data)
+}
+
+// deltaTracker turns the growing snapshots of the reply streamed by the
+// backend into the text added since the previous snapshot.
+type deltaTracker struct {
+	prev string
+}
+
+func (d *deltaTracker) next(text string) string {
+	return text[d.diverge(text):]
+}
+
+// diverge records text as the latest snapshot and returns the length of the
+// prefix it shares with the previous one.
+func (d *deltaTracker) diverge(text string) int {
+	i := 0
+	for i < len(d.prev) && i < len(text) && d.prev[i] == text[i] {
+		i++
+	}
+	if i < len(d.prev) && i < len(text) && !utf8.RuneStart(text[i]) {
+		// don't split a multi-byte character
+		for i > 0 && !utf8.RuneStart(text[i]) {
+			i--
+		}
+	}
+	d.prev = text
+	return i
+}
+
+// rolePrefixHoldBack is how much of the first line of a reply is held back
+// while streaming until the RolePrefix can be told.
+const rolePrefixHoldBack = 64
+
+// textStream computes the deltas of a streamed reply, applying the
+// RolePrefix, PlainText and StreamTransformer options. While streaming only
+// complete lines are converted to plain text, so markdown that isn't closed
+// yet never leaks into the deltas.
+type textStream struct {
+	rolePrefix *regexp.Regexp
+	plainText  bool
+	transform  func(delta string) string
+	deltas     deltaTracker
+	out        strings.Builder
+}
+
+func (c *ChatGPT) newTextStream() *textStream {
+	return &textStream{rolePrefix: c.RolePrefix, plainText: c.PlainText, transform: c.StreamTransformer}
+}
+
+// update takes the reply received so far and returns the delta to deliver.
+func (s *textStream) update(text string, final bool) string {
+	if s.rolePrefix != nil {
+		if !final && len(text) < rolePrefixHoldBack && !strings.Contains(text, "\n") {
+			return ""
+		}
+		text = stripRolePrefix(s.rolePrefix, text)
+	}
+	if s.plainText {
+		if !final {
+			text = text[:strings.LastIndexByte(text, '\n')+1]
+		}
+		text = StripMarkdown(text)
+	}
+	prev := len(s.deltas.prev)
+	i := s.deltas.diverge(text)
+	delta := text[i:]
+	if i < prev {
+		// the snapshot was rewritten rather than extended: the output is
+		// rebuilt from the part of it that didn't change
+		s.out.Reset()
+		s.out.WriteString(s.apply(text[:i]))
+	}
+	delta = s.apply(delta)
+	s.out.WriteString(delta)
+	return delta
+}
+
+func (s *textStream) apply(text string) string {
+	if text == "" || s.transform == nil {
+		return text
+	}
+	return s.transform(text)
+}
+
+func (s *textStream) String() string {
+	return s.out.String()
+}
@@ -0,0 +1,346 @@
+package chatgpt_go
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConversation_readResult_EmptyResponse(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+
+	_, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: nil}, streamHandler{})
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+
+	_, err = c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, streamHandler{})
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+
+	_, err = c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("data: [DONE]\n\n"))}, streamHandler{})
+	assert.ErrorIs(t, err, ErrEmptyResponse)
+}
+
+func TestConversation_readResult(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+	stream := `data: {"message":{"id":"m1","content":{"parts":["Hel"]}},"conversation_id":"c1"}
+
+data: {"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}
+
+data: [DONE]
+
+`
+	result, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) {
+		msg, _ := result.GetMessage()
+		assert.Equal(t, "Hello", msg)
+		assert.Equal(t, "c1", result.ConversationId)
+	}
+}
+
+func TestReadEventStream_Framing(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+	}{
+		{"separated", "data: {\"a\":1}\n\ndata: {\"a\":2}\n\ndata: [DONE]\n\n"},
+		{"unseparated", "data: {\"a\":1}\ndata: {\"a\":2}\ndata: [DONE]\n"},
+		{"crlf", "data: {\"a\":1}\r\n\r\ndata: {\"a\":2}\r\n\r\ndata: [DONE]\r\n\r\n"},
+		{"comments and fields", ": ping\n\nevent: message\ndata: {\"a\":1}\n\nid: 3\ndata: {\"a\":2}\n\n"},
+		{"multi-line payload", "data: {\"a\":\ndata: 1}\n\ndata: {\"a\":2}\n\n"},
+		{"no trailing newline", "data: {\"a\":1}\n\ndata: {\"a\":2}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := readEventStream(context.Background(), strings.NewReader(tt.stream), func(data []byte) error {
+				got = append(got, string(data))
+				return nil
+			})
+			if assert.NoError(t, err) {
+				assert.Len(t, got, 2)
+				assert.JSONEq(t, `{"a":1}`, got[0])
+				assert.JSONEq(t, `{"a":2}`, got[1])
+			}
+		})
+	}
+}
+
+func TestConversation_readResult_ContentType(t *testing.T) {
+	stream := "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hello\"]}}}\n\ndata: [DONE]\n\n"
+	object := `{"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}`
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+		wantErr     error
+	}{
+		{"text/event-stream", stream, "Hello", nil},
+		{"text/event-stream; charset=utf-8", stream, "Hello", nil},
+		{"", stream, "Hello", nil},
+		{"application/json", object, "Hello", nil},
+		{"application/json; charset=utf-8", object, "Hello", nil},
+		{"application/json", `{"detail":"Something is off"}`, "", ErrNoContent},
+		{"application/json", "", "", ErrEmptyResponse},
+		{"text/html; charset=utf-8", "<html>login</html>", "", ErrLoginRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			c := (&ChatGPT{}).NewConversation("", "")
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(tt.body))}
+			resp.Header.Set("content-type", tt.contentType)
+			result, err := c.readResult(resp, streamHandler{})
+			if err == nil {
+				var message string
+				message, err = result.message()
+				assert.Equal(t, tt.want, message)
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConversation_readResult_StreamComplete(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+	stream := `data: {"message":{"id":"m1","content":{"parts":["Hello"]}}}
+
+data: {"type":"message_stream_complete","conversation_id":"c1"}
+
+data: {"message":{"id":"m2","content":{"parts":["ignored"]}}}
+
+`
+	result, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "m1", result.Message.Id)
+		assert.Equal(t, "c1", result.ConversationId)
+	}
+}
+
+func TestConversationResult_CreateTime(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+	stream := `data: {"message":{"id":"m1","create_time":1678000000.5,"update_time":null,"content":{"parts":["Hel"]}}}
+
+data: {"message":{"id":"m1","update_time":1678000001,"content":{"parts":["Hello"]}}}
+
+`
+	result, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Unix(1678000000, 5e8), result.CreateTime())
+		assert.Equal(t, time.Unix(1678000001, 0), result.UpdateTime())
+	}
+	assert.True(t, (&ConversationResult{}).CreateTime().IsZero())
+	assert.True(t, (&ConversationResult{}).UpdateTime().IsZero())
+}
+
+func TestReadEventStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := 0
+	err := readEventStream(ctx, strings.NewReader(strings.Repeat("data: {}\n\n", 10)), func(data []byte) error {
+		events++
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, events)
+}
+
+func TestConversation_readResult_StrictJSON(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+	stream := `data: {"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1","new_field":1}
+
+`
+	_, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	assert.NoError(t, err)
+
+	c.ChatGPT.StrictJSON = true
+	_, err = c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "new_field")
+	}
+
+	stream = `data: {"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}
+
+data: {"type":"moderation","moderation_response":{"flagged":false,"blocked":false,"moderation_id":"modr-1"},"message_id":"m1"}
+
+`
+	_, err = c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	assert.NoError(t, err)
+}
+
+func TestConversationResult_MessageParts(t *testing.T) {
+	c := (&ChatGPT{MaxParts: 2}).NewConversation("", "")
+	stream := `data: {"message":{"id":"m1","content":{"parts":["a","b","c"]}},"conversation_id":"c1"}` + "\n\n"
+	result, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) {
+		parts, truncated := result.MessageParts()
+		assert.Equal(t, []string{"a", "b"}, parts)
+		assert.True(t, truncated)
+		text, _ := result.GetMessage()
+		assert.Equal(t, "a\nb", text)
+		text, _ = result.GetFullMessage()
+		assert.Equal(t, "a\nb\nc", text)
+	}
+
+	c.ChatGPT.MaxParts = 0
+	result, err = c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) {
+		parts, truncated := result.MessageParts()
+		assert.Len(t, parts, 3)
+		assert.False(t, truncated)
+	}
+}
+
+func TestConversationResult_GetFullMessage_NoParts(t *testing.T) {
+	result := &ConversationResult{}
+	result.Message.Id = "m1"
+	text, err := result.GetFullMessage()
+	assert.ErrorIs(t, err, ErrNoContent)
+	assert.Empty(t, text)
+}
+
+func TestConversationResult_EndTurn(t *testing.T) {
+	for stream, want := range map[string]bool{
+		`{"message":{"id":"m1","content":{"parts":["a"]},"end_turn":true}}`:  true,
+		`{"message":{"id":"m1","content":{"parts":["a"]},"end_turn":false}}`: false,
+		`{"message":{"id":"m1","content":{"parts":["a"]},"end_turn":null}}`:  false,
+		`{"message":{"id":"m1","content":{"parts":["a"]}}}`:                  false,
+	} {
+		result := &ConversationResult{}
+		if assert.NoError(t, json.Unmarshal([]byte(stream), result)) {
+			assert.Equal(t, want, result.EndTurn(), stream)
+		}
+	}
+}
+
+func TestConversationResult_GetMessage(t *testing.T) {
+	result := &ConversationResult{}
+	_, err := result.GetMessage()
+	assert.ErrorIs(t, err, ErrNoContent)
+
+	result.Message.Content.Parts = []string{"first", "second"}
+	msg, err := result.GetMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "first\nsecond", msg)
+}
+
+func TestConversation_readResult_Moderation(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+	stream := `data: {"message":{"id":"m1","content":{"parts":["Hello"]}},"conversation_id":"c1"}
+
+data: {"type":"moderation","moderation_response":{"flagged":true,"blocked":false,"moderation_id":"modr-1","categories":["violence"]},"message_id":"m1"}
+
+data: [DONE]
+
+`
+	result, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) && assert.NotNil(t, result.Moderation()) {
+		assert.True(t, result.Moderation().Flagged)
+		assert.Equal(t, []string{"violence"}, result.Moderation().Categories)
+		msg, err := result.GetMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello", msg)
+	}
+}
+
+func TestDeltaTracker(t *testing.T) {
+	d := deltaTracker{}
+	assert.Equal(t, "Hel", d.next("Hel"))
+	assert.Equal(t, "lo", d.next("Hello"))
+	assert.Equal(t, "", d.next("Hello"))
+	assert.Equal(t, " 世界", d.next("Hello 世界"))
+	assert.Equal(t, "p!", d.next("Help!"))
+	assert.Equal(t, "", d.next("He"))
+	assert.Equal(t, "界", d.next("He界"))
+
+	// 世 and 丗 share their first two bytes
+	d = deltaTracker{}
+	d.next("世")
+	assert.Equal(t, "丗", d.next("丗"))
+}
+
+func TestTextStream(t *testing.T) {
+	snapshots := []string{"**Ti", "**Title**\n", "**Title**\n```go\nx := 1", "**Title**\n```go\nx := 1\n```\nsee [docs](http://x)"}
+
+	ts := &textStream{plainText: true}
+	var deltas []string
+	for _, s := range snapshots {
+		if delta := ts.update(s, false); delta != "" {
+			deltas = append(deltas, delta)
+		}
+	}
+	deltas = append(deltas, ts.update(snapshots[len(snapshots)-1], true))
+	assert.Equal(t, []string{"Title\n", "x := 1\n", "see docs"}, deltas)
+	assert.Equal(t, StripMarkdown(snapshots[len(snapshots)-1]), ts.String())
+
+	ts = &textStream{transform: strings.ToUpper}
+	for _, s := range []string{"a", "ab", "abc"} {
+		ts.update(s, false)
+	}
+	ts.update("abc", true)
+	assert.Equal(t, "ABC", ts.String())
+
+	// a rewritten snapshot replaces the output rather than adding to it
+	ts = &textStream{transform: strings.ToUpper}
+	for _, s := range []string{"h", "hi", "Hi", "Hi there"} {
+		ts.update(s, false)
+	}
+	assert.Equal(t, "HI THERE", ts.String())
+}
+
+func BenchmarkReadEventStream_Pings(b *testing.B) {
+	stream := strings.Repeat(": ping\n\nevent: ping\n\n\n", 100) + "data: {\"a\":1}\n\ndata: [DONE]\n\n"
+	r := strings.NewReader(stream)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(stream)
+		_ = readEventStream(context.Background(), r, func(data []byte) error { return nil })
+	}
+}
+
+func TestReadEventStream_LongLine(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	var got []string
+	err := readEventStream(context.Background(), strings.NewReader(": ping\n\ndata: \""+long+"\"\n\ndata: [DONE]\n\n"), func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	if assert.NoError(t, err) && assert.Len(t, got, 1) {
+		assert.Equal(t, `"`+long+`"`, got[0])
+	}
+}
+
+func TestConversation_readResult_ErrorEvent(t *testing.T) {
+	c := (&ChatGPT{}).NewConversation("", "")
+	for _, event := range []string{
+		`{"message":null,"conversation_id":"c1","error":"Something went wrong while streaming"}`,
+		`{"message":null,"conversation_id":"c1","error":{"message":"Something went wrong while streaming","code":"stream_error"}}`,
+	} {
+		stream := "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hel\"]}}}\n\ndata: " + event + "\n\ndata: [DONE]\n\n"
+		_, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+		if assert.ErrorIs(t, err, ErrStreamError) {
+			assert.Contains(t, err.Error(), "Something went wrong while streaming")
+		}
+	}
+
+	stream := "data: {\"message\":{\"id\":\"m1\",\"content\":{\"parts\":[\"Hello\"]}},\"error\":null}\n\ndata: [DONE]\n\n"
+	_, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	assert.NoError(t, err)
+
+	// the stream ends after flagging the reply
+	stream = "data: {\"type\":\"moderation\",\"moderation_response\":{\"flagged\":true,\"blocked\":false},\"message_id\":\"m1\"}\n\ndata: [DONE]\n\n"
+	result, err := c.readResult(&http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(stream))}, streamHandler{})
+	if assert.NoError(t, err) {
+		_, err = result.message()
+		if assert.ErrorIs(t, err, ErrNoContent) {
+			assert.Contains(t, err.Error(), "flagged")
+		}
+	}
+}
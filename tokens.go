@@ -0,0 +1,49 @@
+package chatgpt_go
+
+import "unicode/utf8"
+
+// EstimateTokens roughly estimates how many tokens s is made of: about one
+// token per 4 characters of ASCII text and one per character of other
+// scripts, e.g. CJK. It is a heuristic, not the backend's tokenizer.
+func EstimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// EstimatedTokens returns the estimated number of tokens sent and received
+// on the conversation since it was created or reset, as computed by
+// EstimateTokens over the prompts and replies. The backend doesn't report
+// usage, so this is only good for rough accounting.
+func (c *Conversation) EstimatedTokens() int {
+	return c.estimatedTokens
+}
+
+// Reset starts a new conversation: the next message is sent without a
+// conversation id and the estimated token count starts over.
+func (c *Conversation) Reset() {
+	c.ConversationId = ""
+	c.ParentMessageId = ""
+	c.servedModel = ""
+	c.estimatedTokens = 0
+	c.lastPrompt = nil
+	c.lastPromptParent = ""
+	c.lastOutput = ""
+	c.truncated = false
+}
+
+// countTokens adds the estimated size of a successful exchange.
+func (c *Conversation) countTokens(body *ConversationBody, result *ConversationResult) {
+	for _, m := range body.Messages {
+		for _, part := range m.Content.Parts {
+			c.estimatedTokens += EstimateTokens(part)
+		}
+	}
+	c.estimatedTokens += EstimateTokens(result.text())
+}
@@ -0,0 +1,31 @@
+package chatgpt_go_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	chatgpt_go "github.com/zhan3333/chatgpt-go"
+	"net/http"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Equal(t, 0, chatgpt_go.EstimateTokens(""))
+	assert.Equal(t, 1, chatgpt_go.EstimateTokens("hi"))
+	assert.Equal(t, 3, chatgpt_go.EstimateTokens("hello, world"))
+	assert.Equal(t, 2, chatgpt_go.EstimateTokens("你好"))
+}
+
+func TestConversation_EstimatedTokens(t *testing.T) {
+	client := newTestClient(t, chatgpt_go.ChatGPTOptions{}, func(w http.ResponseWriter, r *http.Request) {
+		writeStream(w, `{"message":{"id":"m1","content":{"parts":["hello, world"]}},"conversation_id":"c1"}`)
+	})
+	conversation := client.NewConversation("", "")
+	for i := 0; i < 2; i++ {
+		_, err := conversation.SendMessage("hi")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 8, conversation.EstimatedTokens())
+
+	conversation.Reset()
+	assert.Equal(t, 0, conversation.EstimatedTokens())
+	assert.Empty(t, conversation.ConversationId)
+}
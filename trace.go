@@ -0,0 +1,134 @@
+package chatgpt_go
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SendMetrics is the timing breakdown of a send. DNS, Connect and TLS are
+// zero when an idle connection was reused.
+//
+// Frames, MaxFrameGap and NonMonotonic describe the quality of the
+// response stream: how many message events were received, the longest
+// wait between two of them, and whether the text of an event ever failed
+// to extend the text of the previous one.
+type SendMetrics struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLS          time.Duration
+	FirstByte    time.Duration
+	Total        time.Duration
+	ConnReused   bool
+	Frames       int
+	MaxFrameGap  time.Duration
+	NonMonotonic bool
+}
+
+// streamStatsKey is the context key of the *streamStats readResult fills.
+type streamStatsKey struct{}
+
+type streamStats struct {
+	frames       int
+	maxGap       time.Duration
+	nonMonotonic bool
+	last         time.Time
+	text         string
+}
+
+func (s *streamStats) observe(text string) {
+	now := time.Now()
+	if s.frames > 0 {
+		if gap := now.Sub(s.last); gap > s.maxGap {
+			s.maxGap = gap
+		}
+		if !strings.HasPrefix(text, s.text) {
+			s.nonMonotonic = true
+		}
+	}
+	s.frames++
+	s.last = now
+	s.text = text
+}
+
+// SendMessageTrace sends message like SendMessage and also returns where
+// the time was spent, from name resolution to the end of the stream. The
+// timings are those of the conversation request: the access token is
+// refreshed beforehand, untraced, and of retried requests only the last
+// attempt is measured.
+func (c *Conversation) SendMessageTrace(message string) (string, *SendMetrics, error) {
+	var (
+		mu                               sync.Mutex
+		metrics                          SendMetrics
+		dnsStart, connectStart, tlsStart time.Time
+	)
+	start := time.Now()
+	if err := c.ChatGPT.RefreshAccessTokenContext(context.Background()); err != nil {
+		return "", &SendMetrics{Total: time.Since(start)}, err
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// a new request, e.g. after a 401 or a retry: forget the
+			// connection of the previous one
+			mu.Lock()
+			metrics.DNS, metrics.Connect, metrics.TLS, metrics.FirstByte = 0, 0, 0, 0
+			metrics.ConnReused = false
+			mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			metrics.DNS = time.Since(dnsStart)
+			mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			connectStart = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			mu.Lock()
+			metrics.Connect = time.Since(connectStart)
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			metrics.TLS = time.Since(tlsStart)
+			mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			metrics.ConnReused = info.Reused
+			mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			metrics.FirstByte = time.Since(start)
+			mu.Unlock()
+		},
+	}
+
+	stats := &streamStats{}
+	ctx := context.WithValue(httptrace.WithClientTrace(context.Background(), trace), streamStatsKey{}, stats)
+	resp, err := c.SendMessageContext(ctx, message)
+
+	mu.Lock()
+	defer mu.Unlock()
+	metrics.Total = time.Since(start)
+	metrics.Frames = stats.frames
+	metrics.MaxFrameGap = stats.maxGap
+	metrics.NonMonotonic = stats.nonMonotonic
+	return resp, &metrics, err
+}